@@ -2,7 +2,6 @@ package yaml
 
 import (
 	"io"
-	"os"
 )
 
 func yaml_insert_token(parser *yaml_parser_t, pos int, token *yaml_token_t) {
@@ -48,9 +47,9 @@ func yaml_string_read_handler(parser *yaml_parser_t, buffer []byte) (n int, err
 	return n, nil
 }
 
-// File read handler.
-func yaml_file_read_handler(parser *yaml_parser_t, buffer []byte) (n int, err error) {
-	return parser.input_file.Read(buffer)
+// Reader read handler.
+func yaml_reader_read_handler(parser *yaml_parser_t, buffer []byte) (n int, err error) {
+	return parser.input_reader.Read(buffer)
 }
 
 // Set a string input.
@@ -63,13 +62,13 @@ func yaml_parser_set_input_string(parser *yaml_parser_t, input []byte) {
 	parser.input_pos = 0
 }
 
-// Set a file input.
-func yaml_parser_set_input_file(parser *yaml_parser_t, file *os.File) {
+// Set a reader input.
+func yaml_parser_set_input_reader(parser *yaml_parser_t, r io.Reader) {
 	if parser.read_handler != nil {
 		panic("must set the input source only once")
 	}
-	parser.read_handler = yaml_file_read_handler
-	parser.input_file = file
+	parser.read_handler = yaml_reader_read_handler
+	parser.input_reader = r
 }
 
 // Set the source encoding.
@@ -155,6 +154,12 @@ func yaml_emitter_set_width(emitter *yaml_emitter_t, width int) {
 	emitter.best_width = width
 }
 
+// [Go] Set if block collections of scalars are emitted in flow style
+// when they fit in the line.
+func yaml_emitter_set_auto_flow(emitter *yaml_emitter_t, auto_flow bool) {
+	emitter.auto_flow = auto_flow
+}
+
 // Set if unescaped non-ASCII characters are allowed.
 func yaml_emitter_set_unicode(emitter *yaml_emitter_t, unicode bool) {
 	emitter.unicode = unicode
@@ -367,6 +372,16 @@ func yaml_mapping_end_event_initialize(event *yaml_event_t) bool {
 	return true
 }
 
+// [Go] Create RAW, with the block style of the collection in value, if any.
+func yaml_raw_event_initialize(event *yaml_event_t, value []byte, style yaml_style_t) bool {
+	*event = yaml_event_t{
+		typ:   yaml_RAW_EVENT,
+		value: value,
+		style: style,
+	}
+	return true
+}
+
 // Destroy an event object.
 func yaml_event_delete(event *yaml_event_t) {
 	*event = yaml_event_t{}
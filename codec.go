@@ -0,0 +1,196 @@
+package yaml
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sync"
+)
+
+// A Codec customizes how values of a single type are marshaled and
+// unmarshaled. It is the alternative to the Marshaler and Unmarshaler
+// interfaces for types that cannot implement them, such as types
+// defined in other packages.
+//
+// Marshal is called with a value of the registered type, and the value
+// it returns is marshaled in its place, as with MarshalYAML.
+//
+// Unmarshal is called with a pointer to a value of the registered type
+// and a function that may be called to unmarshal the original YAML
+// value into some other value, as with UnmarshalYAML.
+//
+// Either function may be nil, in which case the value is handled as
+// usual in that direction.
+type Codec struct {
+	Marshal   func(in interface{}) (interface{}, error)
+	Unmarshal func(out interface{}, unmarshal func(interface{}) error) error
+}
+
+type codecMap map[reflect.Type]*Codec
+
+var globalCodecs = make(codecMap)
+var codecMutex sync.RWMutex
+
+// RegisterCodec registers c to be used for all values of type t by
+// Marshal, Unmarshal, and every Encoder and Decoder. Codecs registered
+// on a specific Encoder or Decoder take precedence.
+//
+// Codecs are registered for the value type rather than a pointer to it;
+// pointers to t are allocated and dereferenced as usual. A codec takes
+// precedence over the Marshaler, Unmarshaler and encoding.TextMarshaler
+// and TextUnmarshaler interfaces that the type might implement.
+//
+// The yaml package registers codecs for url.URL, regexp.Regexp,
+// net.IPNet, big.Int and big.Float. Types such as net.IP and time.Time
+// need none, as they implement encoding.TextMarshaler and
+// TextUnmarshaler, but a codec may still replace that behavior.
+func RegisterCodec(t reflect.Type, c Codec) {
+	codecMutex.Lock()
+	globalCodecs[t] = &c
+	codecMutex.Unlock()
+}
+
+// lookup returns the codec registered for t in m or globally,
+// or nil if there's none.
+func (m codecMap) lookup(t reflect.Type) *Codec {
+	if c, ok := m[t]; ok {
+		return c
+	}
+	codecMutex.RLock()
+	c := globalCodecs[t]
+	codecMutex.RUnlock()
+	return c
+}
+
+// marshalFunc returns the Marshal function of the codec registered
+// for t, or nil if there's none.
+func (m codecMap) marshalFunc(t reflect.Type) func(interface{}) (interface{}, error) {
+	if c := m.lookup(t); c != nil {
+		return c.Marshal
+	}
+	return nil
+}
+
+// unmarshalFunc returns the Unmarshal function of the codec registered
+// for t, or nil if there's none.
+func (m codecMap) unmarshalFunc(t reflect.Type) func(interface{}, func(interface{}) error) error {
+	if c := m.lookup(t); c != nil {
+		return c.Unmarshal
+	}
+	return nil
+}
+
+func (m *codecMap) register(t reflect.Type, c Codec) {
+	if *m == nil {
+		*m = make(codecMap)
+	}
+	(*m)[t] = &c
+}
+
+// unmarshalString unmarshals the original YAML value as a string
+// on behalf of a built-in codec.
+func unmarshalString(unmarshal func(interface{}) error) (string, error) {
+	var s string
+	err := unmarshal(&s)
+	return s, err
+}
+
+func init() {
+	RegisterCodec(reflect.TypeOf(url.URL{}), Codec{
+		Marshal: func(in interface{}) (interface{}, error) {
+			u := in.(url.URL)
+			return u.String(), nil
+		},
+		Unmarshal: func(out interface{}, unmarshal func(interface{}) error) error {
+			s, err := unmarshalString(unmarshal)
+			if err != nil {
+				return err
+			}
+			u, err := url.Parse(s)
+			if err != nil {
+				return fmt.Errorf("yaml: cannot unmarshal %q into url.URL: %v", s, err)
+			}
+			*out.(*url.URL) = *u
+			return nil
+		},
+	})
+	RegisterCodec(reflect.TypeOf(regexp.Regexp{}), Codec{
+		Marshal: func(in interface{}) (interface{}, error) {
+			re := in.(regexp.Regexp)
+			return re.String(), nil
+		},
+		Unmarshal: func(out interface{}, unmarshal func(interface{}) error) error {
+			s, err := unmarshalString(unmarshal)
+			if err != nil {
+				return err
+			}
+			re, err := regexp.Compile(s)
+			if err != nil {
+				return fmt.Errorf("yaml: cannot unmarshal %q into regexp.Regexp: %v", s, err)
+			}
+			*out.(*regexp.Regexp) = *re
+			return nil
+		},
+	})
+	RegisterCodec(reflect.TypeOf(net.IPNet{}), Codec{
+		Marshal: func(in interface{}) (interface{}, error) {
+			ipnet := in.(net.IPNet)
+			return ipnet.String(), nil
+		},
+		Unmarshal: func(out interface{}, unmarshal func(interface{}) error) error {
+			s, err := unmarshalString(unmarshal)
+			if err != nil {
+				return err
+			}
+			_, ipnet, err := net.ParseCIDR(s)
+			if err != nil {
+				return fmt.Errorf("yaml: cannot unmarshal %q into net.IPNet: %v", s, err)
+			}
+			*out.(*net.IPNet) = *ipnet
+			return nil
+		},
+	})
+	RegisterCodec(reflect.TypeOf(big.Int{}), Codec{
+		Marshal: func(in interface{}) (interface{}, error) {
+			i := in.(big.Int)
+			return Number(i.String()), nil
+		},
+		Unmarshal: func(out interface{}, unmarshal func(interface{}) error) error {
+			s, err := unmarshalString(unmarshal)
+			if err != nil {
+				return err
+			}
+			i, err := Number(s).BigInt()
+			if err != nil {
+				return fmt.Errorf("yaml: cannot unmarshal %q into big.Int", s)
+			}
+			out.(*big.Int).Set(i)
+			return nil
+		},
+	})
+	RegisterCodec(reflect.TypeOf(big.Float{}), Codec{
+		Marshal: func(in interface{}) (interface{}, error) {
+			f := in.(big.Float)
+			if f.IsInf() {
+				f, _ := f.Float64()
+				return f, nil
+			}
+			return Number(f.Text('g', -1)), nil
+		},
+		Unmarshal: func(out interface{}, unmarshal func(interface{}) error) error {
+			s, err := unmarshalString(unmarshal)
+			if err != nil {
+				return err
+			}
+			f, err := Number(s).BigFloat()
+			if err != nil {
+				return fmt.Errorf("yaml: cannot unmarshal %q into big.Float", s)
+			}
+			out.(*big.Float).Set(f)
+			return nil
+		},
+	})
+}
@@ -1,12 +1,15 @@
 package yaml
 
 import (
+	"bytes"
 	"encoding"
 	"encoding/base64"
 	"fmt"
+	"io"
 	"math"
 	"reflect"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -26,15 +29,25 @@ type node struct {
 	implicit     bool
 	children     []*node
 	anchors      map[string]*node
+
+	// text is the source of the node, and indent the number of
+	// spaces that its lines after the first are indented by
+	// relative to the document, for block collections.
+	text   []byte
+	indent int
 }
 
 // ----------------------------------------------------------------------------
 // Parser, produces a node tree out of a libyaml event stream.
 
 type parser struct {
-	parser yaml_parser_t
-	event  yaml_event_t
-	doc    *node
+	parser   yaml_parser_t
+	event    yaml_event_t
+	doc      *node
+	doneInit bool
+
+	// end is the index just past the text of the last node parsed.
+	end int
 }
 
 func newParser(b []byte) *parser {
@@ -48,13 +61,37 @@ func newParser(b []byte) *parser {
 	}
 
 	yaml_parser_set_input_string(&p.parser, b)
+	if bytes.HasPrefix(b, []byte(bom_UTF16LE)) || bytes.HasPrefix(b, []byte(bom_UTF16BE)) {
+		p.parser.capture_source = true
+	} else {
+		p.parser.source = bytes.TrimPrefix(b, []byte(bom_UTF8))
+	}
+	return &p
+}
+
+func newParserFromReader(r io.Reader) *parser {
+	p := parser{}
+	if !yaml_parser_initialize(&p.parser) {
+		panic("failed to initialize YAML parser")
+	}
+	yaml_parser_set_input_reader(&p.parser, r)
+	p.parser.capture_source = true
+	return &p
+}
 
+// init consumes the stream start event. It is deferred until the
+// first document is requested so that errors are reported by the
+// caller of parse rather than at construction time.
+func (p *parser) init() {
+	if p.doneInit {
+		return
+	}
 	p.skip()
 	if p.event.typ != yaml_STREAM_START_EVENT {
 		panic("expected stream start event, got " + strconv.Itoa(int(p.event.typ)))
 	}
 	p.skip()
-	return &p
+	p.doneInit = true
 }
 
 func (p *parser) destroy() {
@@ -103,6 +140,7 @@ func (p *parser) anchor(n *node, anchor []byte) {
 }
 
 func (p *parser) parse() *node {
+	p.init()
 	switch p.event.typ {
 	case yaml_SCALAR_EVENT:
 		return p.scalar()
@@ -131,7 +169,19 @@ func (p *parser) node(kind int) *node {
 	}
 }
 
+// text returns the source between the byte indexes start and end.
+func (p *parser) text(start, end int) []byte {
+	offset := p.parser.source_offset
+	return p.parser.source[start-offset : end-offset]
+}
+
 func (p *parser) document() *node {
+	// Documents are independent, so the source before them
+	// is no longer needed.
+	start := p.event.start_mark.source_index
+	p.parser.source = p.parser.source[start-p.parser.source_offset:]
+	p.parser.source_offset = start
+
 	n := p.node(documentNode)
 	n.anchors = make(map[string]*node)
 	p.doc = n
@@ -147,6 +197,8 @@ func (p *parser) document() *node {
 func (p *parser) alias() *node {
 	n := p.node(aliasNode)
 	n.value = string(p.event.anchor)
+	p.end = p.event.end_mark.source_index
+	n.text = p.text(p.event.start_mark.source_index, p.end)
 	p.skip()
 	return n
 }
@@ -157,6 +209,8 @@ func (p *parser) scalar() *node {
 	n.tag = string(p.event.tag)
 	n.implicit = p.event.implicit
 	p.anchor(n, p.event.anchor)
+	p.end = p.event.end_mark.source_index
+	n.text = p.text(p.event.start_mark.source_index, p.end)
 	p.skip()
 	return n
 }
@@ -164,10 +218,13 @@ func (p *parser) scalar() *node {
 func (p *parser) sequence() *node {
 	n := p.node(sequenceNode)
 	p.anchor(n, p.event.anchor)
+	start := p.event.start_mark.source_index
+	block := p.event.sequence_style() == yaml_BLOCK_SEQUENCE_STYLE
 	p.skip()
 	for p.event.typ != yaml_SEQUENCE_END_EVENT {
 		n.children = append(n.children, p.parse())
 	}
+	p.collectionText(n, start, block)
 	p.skip()
 	return n
 }
@@ -175,14 +232,29 @@ func (p *parser) sequence() *node {
 func (p *parser) mapping() *node {
 	n := p.node(mappingNode)
 	p.anchor(n, p.event.anchor)
+	start := p.event.start_mark.source_index
+	block := p.event.mapping_style() == yaml_BLOCK_MAPPING_STYLE
 	p.skip()
 	for p.event.typ != yaml_MAPPING_END_EVENT {
 		n.children = append(n.children, p.parse(), p.parse())
 	}
+	p.collectionText(n, start, block)
 	p.skip()
 	return n
 }
 
+// collectionText sets the text of the collection n that started at
+// the index start, with the current event being its end. The end of
+// a block collection is only known from the end of its last node.
+func (p *parser) collectionText(n *node, start int, block bool) {
+	if block {
+		n.indent = n.column
+	} else {
+		p.end = p.event.end_mark.source_index
+	}
+	n.text = p.text(start, p.end)
+}
+
 // ----------------------------------------------------------------------------
 // Decoder, unmarshals a node into a provided value.
 
@@ -191,6 +263,46 @@ type decoder struct {
 	aliases map[string]bool
 	mapType reflect.Type
 	terrors []string
+
+	// meta collects the metadata of the decoded document, if
+	// requested, and path holds the keys and indexes leading to
+	// the value being decoded while it does.
+	meta       *Metadata
+	path       []string
+	aliasDepth int
+	mergeDepth int
+
+	// key is whether the scalar being decoded is a mapping key.
+	key bool
+
+	decodeOptions
+}
+
+// decodeOptions holds the settings of a Decoder that are carried
+// over to each document it decodes.
+type decodeOptions struct {
+	codecs    codecMap
+	resolvers resolverList
+	hooks     []DecodeHook
+	weak      bool
+	warnings  func(msg string)
+	unions    unionMap
+
+	// caseInsensitive is whether keys that match no struct field
+	// exactly may match one regardless of case.
+	caseInsensitive bool
+
+	// useNumber and useInt64 are whether integers and floats are
+	// decoded into interface{} values as Number, and whether integers
+	// that fit are decoded into them as int64 rather than int.
+	useNumber bool
+	useInt64  bool
+
+	// maps is the representation of mappings decoded into
+	// interface{} values.
+	maps MapType
+
+	structOptions
 }
 
 var (
@@ -207,6 +319,20 @@ func newDecoder() *decoder {
 }
 
 func (d *decoder) terror(n *node, tag string, out reflect.Value) {
+	d.terrors = append(d.terrors, fmt.Sprintf("line %d: cannot unmarshal %s into %s", n.line+1, describe(n, tag), out.Type()))
+}
+
+// warnf reports a problem that didn't prevent n from being decoded
+// to the warnings handler, if there's one.
+func (d *decoder) warnf(n *node, format string, args ...interface{}) {
+	if d.warnings != nil {
+		d.warnings(fmt.Sprintf("line %d: ", n.line+1) + fmt.Sprintf(format, args...))
+	}
+}
+
+// describe returns the tag of n, or tag if n has none, followed
+// by an abbreviation of its value for scalars.
+func describe(n *node, tag string) string {
 	if n.tag != "" {
 		tag = n.tag
 	}
@@ -218,12 +344,12 @@ func (d *decoder) terror(n *node, tag string, out reflect.Value) {
 			value = " `" + value + "`"
 		}
 	}
-	d.terrors = append(d.terrors, fmt.Sprintf("line %d: cannot unmarshal %s%s into %s", n.line+1, shortTag(tag), value, out.Type()))
+	return shortTag(tag) + value
 }
 
-func (d *decoder) callUnmarshaler(n *node, u Unmarshaler) (good bool) {
+func (d *decoder) callUnmarshaler(n *node, unmarshalYAML func(unmarshal func(interface{}) error) error) (good bool) {
 	terrlen := len(d.terrors)
-	err := u.UnmarshalYAML(func(v interface{}) (err error) {
+	err := unmarshalYAML(func(v interface{}) (err error) {
 		defer handleErr(&err)
 		d.unmarshal(n, reflect.ValueOf(v))
 		if len(d.terrors) > terrlen {
@@ -243,11 +369,11 @@ func (d *decoder) callUnmarshaler(n *node, u Unmarshaler) (good bool) {
 	return true
 }
 
-// d.prepare initializes and dereferences pointers and calls UnmarshalYAML
-// if a value is found to implement it.
+// d.prepare initializes and dereferences pointers and calls the registered
+// codec or UnmarshalYAML if a value is found to have one or implement it.
 // It returns the initialized and dereferenced out value, whether
-// unmarshalling was already done by UnmarshalYAML, and if so whether
-// its types unmarshalled appropriately.
+// unmarshalling was already done by a codec or UnmarshalYAML, and if so
+// whether its types unmarshalled appropriately.
 //
 // If n holds a null value, prepare returns before doing anything.
 func (d *decoder) prepare(n *node, out reflect.Value) (newout reflect.Value, unmarshaled, good bool) {
@@ -264,9 +390,23 @@ func (d *decoder) prepare(n *node, out reflect.Value) (newout reflect.Value, unm
 			out = out.Elem()
 			again = true
 		}
+		if out.Type() == rawMessageType {
+			return out, true, d.raw(n, out)
+		}
 		if out.CanAddr() {
+			if unmarshalCodec := d.codecs.unmarshalFunc(out.Type()); unmarshalCodec != nil {
+				ptr := out.Addr().Interface()
+				good = d.callUnmarshaler(n, func(unmarshal func(interface{}) error) error {
+					return unmarshalCodec(ptr, unmarshal)
+				})
+				return out, true, good
+			}
 			if u, ok := out.Addr().Interface().(Unmarshaler); ok {
-				good = d.callUnmarshaler(n, u)
+				good = d.callUnmarshaler(n, u.UnmarshalYAML)
+				return out, true, good
+			}
+			if u, ok := d.jsonUnmarshaler(out); ok {
+				good = d.callJSONUnmarshaler(n, u)
 				return out, true, good
 			}
 		}
@@ -281,6 +421,9 @@ func (d *decoder) unmarshal(n *node, out reflect.Value) (good bool) {
 	case aliasNode:
 		return d.alias(n, out)
 	}
+	if out.Type() == rawMessageType {
+		return d.raw(n, out)
+	}
 	out, unmarshaled, good := d.prepare(n, out)
 	if unmarshaled {
 		return good
@@ -298,16 +441,62 @@ func (d *decoder) unmarshal(n *node, out reflect.Value) (good bool) {
 	return good
 }
 
+// unmarshalKey unmarshals the mapping key n into out, passing the text
+// of scalar keys to encoding.TextUnmarshaler implementations as it is.
+func (d *decoder) unmarshalKey(n *node, out reflect.Value) (good bool) {
+	if n.kind != scalarNode {
+		return d.unmarshal(n, out)
+	}
+	d.key = true
+	good = d.unmarshal(n, out)
+	d.key = false
+	return good
+}
+
 func (d *decoder) document(n *node, out reflect.Value) (good bool) {
 	if len(n.children) == 1 {
 		d.doc = n
 		d.unmarshal(n.children[0], out)
+		if c := n.children[0]; c.kind == scalarNode && (c.tag != "" || c.implicit) {
+			if _, resolved, _ := d.resolvers.resolve(c.tag, c.value); resolved == nil {
+				d.documentDefaults(c, out)
+			}
+		}
 		return true
 	}
 	return false
 }
 
+// documentDefaults sets the fields of the struct out to their defaults
+// when the document decoded into it is null, as held by n, or empty,
+// if n is nil, and so has no mapping to set them.
+func (d *decoder) documentDefaults(n *node, out reflect.Value) {
+	for out.Kind() == reflect.Ptr && !out.IsNil() {
+		out = out.Elem()
+	}
+	if out.Kind() != reflect.Struct {
+		return
+	}
+	if n == nil {
+		n = &node{kind: scalarNode}
+	}
+	sinfo, err := getStructInfo(out.Type(), d.structOptions)
+	if err != nil {
+		panic(err)
+	}
+	d.setDefaults(n, out, sinfo, map[string]bool{})
+}
+
 func (d *decoder) alias(n *node, out reflect.Value) (good bool) {
+	d.aliased(n, func(an *node) {
+		good = d.unmarshal(an, out)
+	})
+	return good
+}
+
+// aliased calls f with the node that the alias n refers to, failing
+// if the anchor is unknown or the node contains the alias itself.
+func (d *decoder) aliased(n *node, f func(an *node)) {
 	an, ok := d.doc.anchors[n.value]
 	if !ok {
 		failf("unknown anchor '%s' referenced", n.value)
@@ -316,9 +505,10 @@ func (d *decoder) alias(n *node, out reflect.Value) (good bool) {
 		failf("anchor '%s' value contains itself", n.value)
 	}
 	d.aliases[n.value] = true
-	good = d.unmarshal(an, out)
+	d.aliasDepth++
+	f(an)
+	d.aliasDepth--
 	delete(d.aliases, n.value)
-	return good
 }
 
 var zeroValue reflect.Value
@@ -332,11 +522,18 @@ func resetMap(out reflect.Value) {
 func (d *decoder) scalar(n *node, out reflect.Value) (good bool) {
 	var tag string
 	var resolved interface{}
+	var custom bool
 	if n.tag == "" && !n.implicit {
 		tag = yaml_STR_TAG
 		resolved = n.value
 	} else {
-		tag, resolved = resolve(n.tag, n.value)
+		tag, resolved, custom = d.resolvers.resolve(n.tag, n.value)
+		if custom && resolved != nil && !reflect.TypeOf(resolved).AssignableTo(out.Type()) {
+			// Values that can't hold what a custom resolver
+			// produced see the scalar as it resolves by default.
+			tag, resolved = resolve(n.tag, n.value)
+			custom = false
+		}
 		if tag == yaml_BINARY_TAG {
 			data, err := base64.StdEncoding.DecodeString(resolved.(string))
 			if err != nil {
@@ -353,7 +550,13 @@ func (d *decoder) scalar(n *node, out reflect.Value) (good bool) {
 		}
 		return true
 	}
-	if s, ok := resolved.(string); ok && out.CanAddr() {
+	s, text := resolved.(string)
+	if d.key && n.implicit {
+		// As in encoding/json, plain keys are unmarshalled from
+		// their text, whatever it resolves to.
+		s, text = n.value, true
+	}
+	if text && out.CanAddr() {
 		if u, ok := out.Addr().Interface().(encoding.TextUnmarshaler); ok {
 			err := u.UnmarshalText([]byte(s))
 			if err != nil {
@@ -362,6 +565,18 @@ func (d *decoder) scalar(n *node, out reflect.Value) (good bool) {
 			return true
 		}
 	}
+	if custom {
+		out.Set(reflect.ValueOf(resolved))
+		return true
+	}
+	if out.Type() == numberType {
+		if isNumberTag(tag) {
+			out.SetString(n.value)
+			return true
+		}
+		d.terror(n, tag, out)
+		return false
+	}
 	switch out.Kind() {
 	case reflect.String:
 		if tag == yaml_BINARY_TAG {
@@ -372,12 +587,19 @@ func (d *decoder) scalar(n *node, out reflect.Value) (good bool) {
 			good = true
 		}
 	case reflect.Interface:
+		if i, ok := resolved.(int); ok && d.useInt64 {
+			resolved = int64(i)
+		}
 		if resolved == nil {
 			out.Set(reflect.Zero(out.Type()))
-		} else {
-			out.Set(reflect.ValueOf(resolved))
+			good = true
+		} else if d.useNumber && isNumberTag(tag) && numberType.AssignableTo(out.Type()) {
+			out.Set(reflect.ValueOf(Number(n.value)))
+			good = true
+		} else if rv := reflect.ValueOf(resolved); rv.Type().AssignableTo(out.Type()) {
+			out.Set(rv)
+			good = true
 		}
-		good = true
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		switch resolved := resolved.(type) {
 		case int:
@@ -462,12 +684,102 @@ func (d *decoder) scalar(n *node, out reflect.Value) (good bool) {
 			good = true
 		}
 	}
+	if good && d.weak && out.Kind() == reflect.String && tag != yaml_STR_TAG && tag != yaml_BINARY_TAG {
+		d.warnf(n, "stringified %s into %s", describe(n, tag), out.Type())
+	}
 	if !good {
+		good = d.convert(tag, resolved, out)
+	}
+	handled := good
+	if !handled && d.weak {
+		good, handled = d.weaken(n, tag, resolved, out)
+	}
+	if !handled {
 		d.terror(n, tag, out)
 	}
 	return good
 }
 
+// weaken implements the conversions of weakly-typed decoding for a
+// scalar that doesn't otherwise fit out: quoted numbers and booleans
+// are parsed as if they were plain, and a single value is decoded as
+// a one-element slice. It returns whether the conversion succeeded,
+// and whether it was attempted at all, in which case any error has
+// already been recorded.
+func (d *decoder) weaken(n *node, tag string, resolved interface{}, out reflect.Value) (good, handled bool) {
+	switch out.Kind() {
+	case reflect.Slice:
+		if out.Type().Elem() == mapItemType {
+			return false, false
+		}
+		d.warnf(n, "decoded %s as a one-element %s", describe(n, tag), out.Type())
+		e := reflect.New(out.Type().Elem()).Elem()
+		if d.unmarshal(n, e) {
+			slice := reflect.MakeSlice(out.Type(), 1, 1)
+			slice.Index(0).Set(e)
+			out.Set(slice)
+			return true, true
+		}
+		return false, true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Bool:
+		s, ok := resolved.(string)
+		if !ok {
+			return false, false
+		}
+		s = strings.TrimSpace(s)
+		switch rtag, _ := resolve("", s); rtag {
+		case yaml_INT_TAG, yaml_FLOAT_TAG, yaml_BOOL_TAG:
+			d.warnf(n, "parsed %s into %s", describe(n, tag), out.Type())
+			plain := &node{kind: scalarNode, line: n.line, column: n.column, value: s, implicit: true}
+			return d.scalar(plain, out), true
+		}
+	}
+	return false, false
+}
+
+// A DecodeHook is called when a scalar value doesn't fit the type it
+// is being decoded into, before the mismatch is recorded as an error.
+// It receives the short form of the scalar's tag (e.g. "!!int"), its
+// resolved value, and the target type, and returns the converted value
+// and true, or false if it can't convert the value. See AddDecodeHook.
+type DecodeHook func(tag string, value interface{}, t reflect.Type) (interface{}, bool)
+
+// convert runs the decode hooks in order until one of them converts
+// resolved into a value that can be stored in out.
+func (d *decoder) convert(tag string, resolved interface{}, out reflect.Value) bool {
+	for _, hook := range d.hooks {
+		if v, ok := hook(shortTag(tag), resolved, out.Type()); ok && setConverted(out, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// setConverted stores v into out if it is assignable or convertible to
+// its type, with the exception of conversions from numbers to strings.
+func setConverted(out reflect.Value, v interface{}) bool {
+	if v == nil {
+		switch out.Kind() {
+		case reflect.Interface, reflect.Ptr, reflect.Map, reflect.Slice:
+			out.Set(reflect.Zero(out.Type()))
+			return true
+		}
+		return false
+	}
+	vv := reflect.ValueOf(v)
+	if vv.Type().AssignableTo(out.Type()) {
+		out.Set(vv)
+		return true
+	}
+	if vv.Type().ConvertibleTo(out.Type()) && (out.Kind() != reflect.String || vv.Kind() == reflect.String) {
+		out.Set(vv.Convert(out.Type()))
+		return true
+	}
+	return false
+}
+
 func settableValueOf(i interface{}) reflect.Value {
 	v := reflect.ValueOf(i)
 	sv := reflect.New(v.Type()).Elem()
@@ -483,6 +795,10 @@ func (d *decoder) sequence(n *node, out reflect.Value) (good bool) {
 	case reflect.Slice:
 		out.Set(reflect.MakeSlice(out.Type(), l, l))
 	case reflect.Interface:
+		if out.NumMethod() > 0 {
+			d.terror(n, yaml_SEQ_TAG, out)
+			return false
+		}
 		// No type hints. Will have to use a generic sequence.
 		iface = out
 		out = settableValueOf(make([]interface{}, l))
@@ -495,10 +811,12 @@ func (d *decoder) sequence(n *node, out reflect.Value) (good bool) {
 	j := 0
 	for i := 0; i < l; i++ {
 		e := reflect.New(et).Elem()
+		d.pushPath("[" + strconv.Itoa(i) + "]")
 		if ok := d.unmarshal(n.children[i], e); ok {
 			out.Index(j).Set(e)
 			j++
 		}
+		d.popPath()
 	}
 	out.Set(out.Slice(0, j))
 	if iface.IsValid() {
@@ -510,16 +828,33 @@ func (d *decoder) sequence(n *node, out reflect.Value) (good bool) {
 func (d *decoder) mapping(n *node, out reflect.Value) (good bool) {
 	switch out.Kind() {
 	case reflect.Struct:
+		if out.Type() == orderedMapType {
+			return d.mappingOrdered(n, out)
+		}
 		return d.mappingStruct(n, out)
 	case reflect.Slice:
 		return d.mappingSlice(n, out)
 	case reflect.Map:
 		// okay
 	case reflect.Interface:
+		if u := d.unions.lookup(out.Type()); u != nil {
+			return d.union(n, out, u)
+		}
+		if out.NumMethod() > 0 {
+			d.terror(n, yaml_MAP_TAG, out)
+			return false
+		}
 		if d.mapType.Kind() == reflect.Map {
 			iface := out
 			out = reflect.MakeMap(d.mapType)
 			iface.Set(out)
+		} else if d.mapType == orderedMapType {
+			m := reflect.New(orderedMapType)
+			if !d.mappingOrdered(n, m.Elem()) {
+				return false
+			}
+			out.Set(m)
+			return true
 		} else {
 			slicev := reflect.New(d.mapType).Elem()
 			if !d.mappingSlice(n, slicev) {
@@ -551,7 +886,7 @@ func (d *decoder) mapping(n *node, out reflect.Value) (good bool) {
 			continue
 		}
 		k := reflect.New(kt).Elem()
-		if d.unmarshal(n.children[i], k) {
+		if d.unmarshalKey(n.children[i], k) {
 			kkind := k.Kind()
 			if kkind == reflect.Interface {
 				kkind = k.Elem().Kind()
@@ -560,9 +895,11 @@ func (d *decoder) mapping(n *node, out reflect.Value) (good bool) {
 				failf("invalid map key: %#v", k.Interface())
 			}
 			e := reflect.New(et).Elem()
+			d.pushPath(fmt.Sprint(k.Interface()))
 			if d.unmarshal(n.children[i+1], e) {
 				out.SetMapIndex(k, e)
 			}
+			d.popPath()
 		}
 	}
 	d.mapType = mapType
@@ -590,9 +927,11 @@ func (d *decoder) mappingSlice(n *node, out reflect.Value) (good bool) {
 		k := reflect.ValueOf(&item.Key).Elem()
 		if d.unmarshal(n.children[i], k) {
 			v := reflect.ValueOf(&item.Value).Elem()
+			d.pushPath(fmt.Sprint(item.Key))
 			if d.unmarshal(n.children[i+1], v) {
 				slice = append(slice, item)
 			}
+			d.popPath()
 		}
 	}
 	out.Set(reflect.ValueOf(slice))
@@ -601,10 +940,32 @@ func (d *decoder) mappingSlice(n *node, out reflect.Value) (good bool) {
 }
 
 func (d *decoder) mappingStruct(n *node, out reflect.Value) (good bool) {
-	sinfo, err := getStructInfo(out.Type())
+	sinfo, err := getStructInfo(out.Type(), d.structOptions)
 	if err != nil {
 		panic(err)
 	}
+	if sinfo.InlineMap != -1 {
+		inlineMap := out.Field(sinfo.InlineMap)
+		inlineMap.Set(reflect.New(inlineMap.Type()).Elem())
+	}
+	if sinfo.Position != -1 {
+		out.Field(sinfo.Position).Set(reflect.ValueOf(nodePosition(n)))
+	}
+	if sinfo.KeyPositions != -1 {
+		out.Field(sinfo.KeyPositions).Set(reflect.ValueOf(map[string]Position{}))
+	}
+	set := make(map[string]bool)
+	d.structFields(n, out, sinfo, set)
+	d.checkRequired(n, out, sinfo, set)
+	d.setDefaults(n, out, sinfo, set)
+	d.validate(n, out)
+	return true
+}
+
+// structFields decodes the keys of the mapping n into the fields of
+// the struct out, including keys merged into n, and records the keys
+// of the fields it sets in set.
+func (d *decoder) structFields(n *node, out reflect.Value, sinfo *structInfo, set map[string]bool) {
 	name := settableValueOf("")
 	l := len(n.children)
 
@@ -612,37 +973,119 @@ func (d *decoder) mappingStruct(n *node, out reflect.Value) (good bool) {
 	var elemType reflect.Type
 	if sinfo.InlineMap != -1 {
 		inlineMap = out.Field(sinfo.InlineMap)
-		inlineMap.Set(reflect.New(inlineMap.Type()).Elem())
 		elemType = inlineMap.Type().Elem()
 	}
 
 	for i := 0; i < l; i += 2 {
 		ni := n.children[i]
 		if isMerge(ni) {
-			d.merge(n.children[i+1], out)
+			d.mergeNodes(n.children[i+1], func(m *node) {
+				if m.kind == aliasNode {
+					d.aliased(m, func(an *node) {
+						d.structFields(an, out, sinfo, set)
+					})
+				} else {
+					d.structFields(m, out, sinfo, set)
+				}
+			})
 			continue
 		}
 		if !d.unmarshal(ni, name) {
 			continue
 		}
-		if info, ok := sinfo.FieldsMap[name.String()]; ok {
-			var field reflect.Value
-			if info.Inline == nil {
-				field = out.Field(info.Num)
-			} else {
-				field = out.FieldByIndex(info.Inline)
+		if sinfo.KeyPositions != -1 {
+			out.Field(sinfo.KeyPositions).SetMapIndex(name, reflect.ValueOf(nodePosition(ni)))
+		}
+		info, ok := d.field(ni, sinfo, name.String())
+		if ok {
+			d.pushPath(info.Key)
+		} else {
+			d.pushPath(name.String())
+		}
+		if ok {
+			field := info.value(out, true)
+			d.recordSet()
+			if d.unmarshal(n.children[i+1], field) && info.Checks != nil {
+				d.check(n.children[i+1], &info, field)
 			}
-			d.unmarshal(n.children[i+1], field)
+			set[info.Key] = true
 		} else if sinfo.InlineMap != -1 {
 			if inlineMap.IsNil() {
 				inlineMap.Set(reflect.MakeMap(inlineMap.Type()))
 			}
+			key := name
+			if keyType := inlineMap.Type().Key(); keyType != name.Type() {
+				key = reflect.New(keyType).Elem()
+				if !d.unmarshalKey(ni, key) {
+					d.popPath()
+					continue
+				}
+			}
 			value := reflect.New(elemType).Elem()
 			d.unmarshal(n.children[i+1], value)
-			inlineMap.SetMapIndex(name, value)
+			inlineMap.SetMapIndex(key, value)
+		} else {
+			d.recordUnused()
 		}
+		d.popPath()
 	}
-	return true
+}
+
+// field returns the field of sinfo that the mapping key k, holding key,
+// refers to. It falls back to the aliases of the fields, reporting their
+// use, and to a case-insensitive match if enabled.
+func (d *decoder) field(k *node, sinfo *structInfo, key string) (info fieldInfo, ok bool) {
+	if info, ok = sinfo.FieldsMap[key]; ok {
+		return info, true
+	}
+	name := key
+	if d.caseInsensitive {
+		if folded, ok := sinfo.Folded[strings.ToLower(key)]; ok {
+			name = folded
+		}
+	}
+	if info, ok = sinfo.FieldsMap[name]; ok {
+		return info, true
+	}
+	if fieldKey, ok := sinfo.Aliases[name]; ok {
+		d.warnf(k, "key %q is deprecated, use %q instead", key, fieldKey)
+		return sinfo.FieldsMap[fieldKey], true
+	}
+	return info, false
+}
+
+// setDefaults decodes the default value of each field of the struct out
+// whose key isn't in set, and recurses into the struct values among them
+// that have defaults of their own. Problems are reported at the line of
+// the mapping n that out was decoded from.
+func (d *decoder) setDefaults(n *node, out reflect.Value, sinfo *structInfo, set map[string]bool) {
+	if !sinfo.HasDefaults {
+		return
+	}
+	for _, info := range sinfo.FieldsList {
+		if set[info.Key] {
+			continue
+		}
+		field := info.value(out, false)
+		if !field.IsValid() {
+			// Inlined through a nil pointer that the document
+			// didn't need to allocate.
+			continue
+		}
+		if info.Default != nil {
+			dn := *info.Default
+			dn.line, dn.column = n.line, n.column
+			d.unmarshal(&dn, field)
+		} else if field.Kind() == reflect.Struct {
+			if fsinfo, err := getStructInfo(field.Type(), d.structOptions); err == nil {
+				d.setDefaults(n, field, fsinfo, map[string]bool{})
+			}
+		}
+	}
+}
+
+func nodePosition(n *node) Position {
+	return Position{Line: n.line + 1, Column: n.column + 1}
 }
 
 func failWantMap() {
@@ -650,15 +1093,26 @@ func failWantMap() {
 }
 
 func (d *decoder) merge(n *node, out reflect.Value) {
+	d.mergeNodes(n, func(m *node) {
+		d.unmarshal(m, out)
+	})
+}
+
+// mergeNodes checks that n is a valid value for a merge key and calls
+// f with each of the mapping or alias nodes that it holds, in the order
+// in which they must be merged.
+func (d *decoder) mergeNodes(n *node, f func(m *node)) {
+	d.mergeDepth++
+	defer func() { d.mergeDepth-- }()
 	switch n.kind {
 	case mappingNode:
-		d.unmarshal(n, out)
+		f(n)
 	case aliasNode:
 		an, ok := d.doc.anchors[n.value]
 		if ok && an.kind != mappingNode {
 			failWantMap()
 		}
-		d.unmarshal(n, out)
+		f(n)
 	case sequenceNode:
 		// Step backwards as earlier nodes take precedence.
 		for i := len(n.children) - 1; i >= 0; i-- {
@@ -671,7 +1125,7 @@ func (d *decoder) merge(n *node, out reflect.Value) {
 			} else if ni.kind != mappingNode {
 				failWantMap()
 			}
-			d.unmarshal(ni, out)
+			f(ni)
 		}
 	default:
 		failWantMap()
@@ -1,12 +1,19 @@
 package yaml_test
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	. "gopkg.in/check.v1"
 	"gopkg.in/yaml.v2"
+	"io"
 	"math"
+	"math/big"
 	"net"
+	"net/url"
 	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -481,6 +488,36 @@ var unmarshalTests = []struct {
 			C map[string]int `yaml:",inline"`
 		}{1, map[string]int{"b": 2, "c": 3}},
 	},
+	{
+		"a: 1\n2: b\nc: [3]\n",
+		&struct {
+			A int
+			C map[interface{}]interface{} `yaml:",inline"`
+		}{1, map[interface{}]interface{}{2: "b", "c": []interface{}{3}}},
+	},
+	{
+		"a: 1\nb/c: 2\n",
+		&struct {
+			A int
+			C map[textKey]int `yaml:",inline"`
+		}{1, map[textKey]int{{"b", "c"}: 2}},
+	},
+
+	// Pointer to struct inlining
+	{
+		"a: 1\nb: 2\nc: 3\n",
+		&struct {
+			A int
+			C *inlineB `yaml:",inline"`
+		}{1, &inlineB{2, inlineC{3}}},
+	},
+	{
+		"a: 1\n",
+		&struct {
+			A int
+			C *inlineB `yaml:",inline"`
+		}{A: 1},
+	},
 
 	// bug 1243827
 	{
@@ -559,6 +596,32 @@ var unmarshalTests = []struct {
 		"a: []",
 		&struct{ A []int }{[]int{}},
 	},
+
+	// Built-in codecs.
+	{
+		"a: http://example.com/path?q=1\n",
+		map[string]*url.URL{"a": {Scheme: "http", Host: "example.com", Path: "/path", RawQuery: "q=1"}},
+	},
+	{
+		"a: 10.0.0.0/8\n",
+		map[string]net.IPNet{"a": {IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(8, 32)}},
+	},
+	{
+		"a: 123456789012345678901234567890\n",
+		map[string]*big.Int{"a": bigInt("123456789012345678901234567890")},
+	},
+	{
+		"a: 0x10\n",
+		map[string]big.Int{"a": *big.NewInt(16)},
+	},
+}
+
+func bigInt(s string) *big.Int {
+	i, ok := new(big.Int).SetString(s, 0)
+	if !ok {
+		panic("invalid big.Int literal: " + s)
+	}
+	return i
 }
 
 type M map[interface{}]interface{}
@@ -568,10 +631,42 @@ type inlineB struct {
 	inlineC `yaml:",inline"`
 }
 
+// textKey is a map key marshalled as text, with its parts separated
+// by a slash.
+type textKey struct {
+	a, b string
+}
+
+func (k textKey) MarshalText() ([]byte, error) {
+	return []byte(k.a + "/" + k.b), nil
+}
+
+func (k *textKey) UnmarshalText(text []byte) error {
+	parts := strings.SplitN(string(text), "/", 2)
+	if len(parts) != 2 {
+		return errors.New("missing slash in key")
+	}
+	k.a, k.b = parts[0], parts[1]
+	return nil
+}
+
 type inlineC struct {
 	C int
 }
 
+// hexKey is a map key marshalled as hexadecimal text.
+type hexKey int
+
+func (k hexKey) MarshalText() ([]byte, error) {
+	return []byte(strconv.FormatInt(int64(k), 16)), nil
+}
+
+func (k *hexKey) UnmarshalText(text []byte) error {
+	i, err := strconv.ParseInt(string(text), 16, 0)
+	*k = hexKey(i)
+	return err
+}
+
 func (s *S) TestUnmarshal(c *C) {
 	for _, item := range unmarshalTests {
 		t := reflect.ValueOf(item.value).Type()
@@ -929,6 +1024,1179 @@ func (s *S) TestUnmarshalNull(c *C) {
 	}
 }
 
+func (s *S) TestUnmarshalRegexpCodec(c *C) {
+	var v struct{ A *regexp.Regexp }
+	err := yaml.Unmarshal([]byte("a: ^f(o+)$"), &v)
+	c.Assert(err, IsNil)
+	c.Assert(v.A.String(), Equals, "^f(o+)$")
+	c.Assert(v.A.MatchString("fooo"), Equals, true)
+
+	err = yaml.Unmarshal([]byte("a: f(o"), &v)
+	c.Assert(err, ErrorMatches, "yaml: cannot unmarshal \"f\\(o\" into regexp.Regexp: .*")
+}
+
+type codecPoint struct {
+	X, Y int
+}
+
+func (s *S) TestDecoderCodec(c *C) {
+	dec := yaml.NewDecoder(strings.NewReader("a: 1,2\nb: [3,4]\n"))
+	dec.RegisterCodec(reflect.TypeOf(codecPoint{}), yaml.Codec{
+		Unmarshal: func(out interface{}, unmarshal func(interface{}) error) error {
+			var s string
+			if err := unmarshal(&s); err != nil {
+				var l []int
+				if err := unmarshal(&l); err != nil {
+					return err
+				}
+				s = fmt.Sprintf("%d,%d", l[0], l[1])
+			}
+			p := out.(*codecPoint)
+			_, err := fmt.Sscanf(s, "%d,%d", &p.X, &p.Y)
+			return err
+		},
+	})
+	var v map[string]*codecPoint
+	err := dec.Decode(&v)
+	c.Assert(err, IsNil)
+	c.Assert(v, DeepEquals, map[string]*codecPoint{"a": {1, 2}, "b": {3, 4}})
+
+	// Codecs registered on a decoder don't leak into Unmarshal.
+	err = yaml.Unmarshal([]byte("a: 1,2"), &v)
+	c.Assert(err, ErrorMatches, "yaml: unmarshal errors:\n  line 1: cannot unmarshal !!str `1,2` into yaml_test.codecPoint")
+}
+
+type quantity string
+
+var quantityResolver = yaml.Resolver{
+	Hint: "0123456789",
+	Resolve: func(s string) (string, interface{}, bool) {
+		if quantityRE.MatchString(s) {
+			return "!quantity", quantity(s), true
+		}
+		return "", nil, false
+	},
+}
+
+var quantityRE = regexp.MustCompile(`^[0-9]+(Ki|Mi|Gi)$`)
+
+func (s *S) TestDecoderResolver(c *C) {
+	data := "a: 10Gi\nb: [1Ki, 10, '2Mi']\nc: 3Gi\nd: 4Gi\n"
+	dec := yaml.NewDecoder(strings.NewReader(data))
+	dec.RegisterResolver(quantityResolver)
+	var v map[string]interface{}
+	err := dec.Decode(&v)
+	c.Assert(err, IsNil)
+	c.Assert(v, DeepEquals, map[string]interface{}{
+		"a": quantity("10Gi"),
+		"b": []interface{}{quantity("1Ki"), 10, "2Mi"},
+		"c": quantity("3Gi"),
+		"d": quantity("4Gi"),
+	})
+
+	type T struct {
+		A quantity
+		B []interface{}
+		C string
+		D int
+	}
+	dec = yaml.NewDecoder(strings.NewReader(data))
+	dec.RegisterResolver(quantityResolver)
+	var t T
+	err = dec.Decode(&t)
+	c.Assert(err, ErrorMatches, "yaml: unmarshal errors:\n  line 4: cannot unmarshal !!str `4Gi` into int")
+	c.Assert(t, DeepEquals, T{A: "10Gi", B: []interface{}{quantity("1Ki"), 10, "2Mi"}, C: "3Gi"})
+
+	// Resolvers may produce nulls.
+	dec = yaml.NewDecoder(strings.NewReader("a: nothing\nb: nothing\n"))
+	dec.RegisterResolver(yaml.Resolver{
+		Resolve: func(s string) (string, interface{}, bool) {
+			return "!!null", nil, s == "nothing"
+		},
+	})
+	var n struct {
+		A interface{}
+		B int
+	}
+	n.A, n.B = 1, 2
+	c.Assert(dec.Decode(&n), IsNil)
+	c.Assert(n.A, IsNil)
+	c.Assert(n.B, Equals, 0)
+}
+
+func (s *S) TestDecoderHooks(c *C) {
+	type T struct {
+		Port    int
+		Ports   []int
+		Timeout time.Duration
+		Name    string
+	}
+	data := "port: '8080'\nports: 80\ntimeout: '30'\nname: x\n"
+	dec := yaml.NewDecoder(strings.NewReader(data))
+	var calls []string
+	dec.AddDecodeHook(func(tag string, value interface{}, t reflect.Type) (interface{}, bool) {
+		calls = append(calls, fmt.Sprintf("%s %v %s", tag, value, t))
+		if s, ok := value.(string); ok && t.Kind() == reflect.Int {
+			i, err := strconv.Atoi(s)
+			return i, err == nil
+		}
+		return nil, false
+	})
+	dec.AddDecodeHook(func(tag string, value interface{}, t reflect.Type) (interface{}, bool) {
+		if t.Kind() == reflect.Slice {
+			return []interface{}{value}, true // Not assignable, so ignored.
+		}
+		if s, ok := value.(string); ok && t == reflect.TypeOf(time.Duration(0)) {
+			d, err := time.ParseDuration(s + "s")
+			return d, err == nil
+		}
+		return nil, false
+	})
+	var v T
+	err := dec.Decode(&v)
+	c.Assert(err, ErrorMatches, "yaml: unmarshal errors:\n  line 2: cannot unmarshal !!int `80` into \\[\\]int")
+	c.Assert(v, DeepEquals, T{Port: 8080, Timeout: 30 * time.Second, Name: "x"})
+	c.Assert(calls, DeepEquals, []string{
+		"!!str 8080 int",
+		"!!int 80 []int",
+		"!!str 30 time.Duration",
+	})
+}
+
+func (s *S) TestDecoderWeaklyTyped(c *C) {
+	type T struct {
+		Replicas int
+		Enabled  bool
+		Ratio    float64
+		Ports    []int
+		Tags     []string
+		Name     string
+		Small    int8
+		Count    int
+	}
+	data := "replicas: '3'\nenabled: \"true\"\nratio: ' 0.5 '\nports: 8080\ntags: '80'\nname: 42\nsmall: '300'\ncount: many\n"
+	dec := yaml.NewDecoder(strings.NewReader(data))
+	dec.SetWeaklyTyped(true)
+	var warnings []string
+	dec.SetWarningHandler(func(msg string) { warnings = append(warnings, msg) })
+	var v T
+	err := dec.Decode(&v)
+	c.Assert(err, ErrorMatches, "yaml: unmarshal errors:\n"+
+		"  line 7: cannot unmarshal !!int `300` into int8\n"+
+		"  line 8: cannot unmarshal !!str `many` into int")
+	c.Assert(v, DeepEquals, T{
+		Replicas: 3,
+		Enabled:  true,
+		Ratio:    0.5,
+		Ports:    []int{8080},
+		Tags:     []string{"80"},
+		Name:     "42",
+	})
+	c.Assert(warnings, DeepEquals, []string{
+		"line 1: parsed !!str `3` into int",
+		"line 2: parsed !!str `true` into bool",
+		"line 3: parsed !!str ` 0.5 ` into float64",
+		"line 4: decoded !!int `8080` as a one-element []int",
+		"line 5: decoded !!str `80` as a one-element []string",
+		"line 6: stringified !!int `42` into string",
+		"line 7: parsed !!str `300` into int8",
+	})
+
+	// Without the option, the same document fails to decode.
+	v = T{}
+	err = yaml.Unmarshal([]byte(data), &v)
+	c.Assert(err, ErrorMatches, "(?s)yaml: unmarshal errors:\n  line 1: cannot unmarshal !!str `3` into int\n.*")
+}
+
+func (s *S) TestDecoderMultipleDocuments(c *C) {
+	dec := yaml.NewDecoder(strings.NewReader("a: 1\n---\na: 2\n---\n- b\n"))
+	var values []interface{}
+	for {
+		var v interface{}
+		err := dec.Decode(&v)
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, IsNil)
+		values = append(values, v)
+	}
+	c.Assert(values, DeepEquals, []interface{}{
+		map[interface{}]interface{}{"a": 1},
+		map[interface{}]interface{}{"a": 2},
+		[]interface{}{"b"},
+	})
+	var v interface{}
+	c.Assert(dec.Decode(&v), Equals, io.EOF)
+}
+
+func (s *S) TestDecoderEmpty(c *C) {
+	var v interface{}
+	err := yaml.NewDecoder(strings.NewReader("")).Decode(&v)
+	c.Assert(err, Equals, io.EOF)
+}
+
+type defaultsInner struct {
+	Port  int      `yaml:",default=80"`
+	Hosts []string `default:"[localhost, 127.0.0.1]"`
+}
+
+type defaultsOuter struct {
+	Name    string        `yaml:"name,default=unnamed"`
+	Enabled *bool         `yaml:",default=true"`
+	Timeout time.Duration `default:"30s"`
+	Server  defaultsInner
+	Backup  *defaultsInner
+	Inline  defaultsInner `yaml:",inline"`
+}
+
+func (s *S) TestUnmarshalDefaults(c *C) {
+	var v defaultsOuter
+	err := yaml.Unmarshal([]byte("name: x\nport: 8080\nbackup: {hosts: []}\n"), &v)
+	c.Assert(err, IsNil)
+	enabled := true
+	c.Assert(v, DeepEquals, defaultsOuter{
+		Name:    "x",
+		Enabled: &enabled,
+		Timeout: 30 * time.Second,
+		Server:  defaultsInner{80, []string{"localhost", "127.0.0.1"}},
+		Backup:  &defaultsInner{80, []string{}},
+		Inline:  defaultsInner{8080, []string{"localhost", "127.0.0.1"}},
+	})
+
+	// Explicit nulls count as being set.
+	v = defaultsOuter{}
+	err = yaml.Unmarshal([]byte("name: ~\nenabled: null\n"), &v)
+	c.Assert(err, IsNil)
+	c.Assert(v.Name, Equals, "")
+	c.Assert(v.Enabled, IsNil)
+	c.Assert(v.Server.Port, Equals, 80)
+}
+
+func (s *S) TestUnmarshalDefaultsEmptyDocument(c *C) {
+	want := defaultsInner{80, []string{"localhost", "127.0.0.1"}}
+	for _, data := range []string{"", "# nothing\n", "---\n", "~", "null\n", "--- !!null\n"} {
+		var v defaultsInner
+		c.Assert(yaml.Unmarshal([]byte(data), &v), IsNil)
+		c.Assert(v, DeepEquals, want, Commentf("document %q", data))
+	}
+
+	var v defaultsInner
+	c.Assert(yaml.NewDecoder(strings.NewReader("--- # nothing\n")).Decode(&v), IsNil)
+	c.Assert(v, DeepEquals, want)
+
+	var p *defaultsInner
+	c.Assert(yaml.Unmarshal([]byte(""), &p), IsNil)
+	c.Assert(p, IsNil)
+	c.Assert(yaml.Unmarshal([]byte("~"), &p), IsNil)
+	c.Assert(p, DeepEquals, &want)
+}
+
+func (s *S) TestUnmarshalDefaultsInlinePointer(c *C) {
+	type T struct {
+		Name  string
+		Inner *defaultsInner `yaml:",inline"`
+	}
+	var v T
+	c.Assert(yaml.Unmarshal([]byte("name: a\n"), &v), IsNil)
+	c.Assert(v, DeepEquals, T{Name: "a"})
+	data, err := yaml.Marshal(&v)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "name: a\n")
+
+	v = T{}
+	c.Assert(yaml.Unmarshal([]byte("name: a\nhosts: [h]\n"), &v), IsNil)
+	c.Assert(v, DeepEquals, T{"a", &defaultsInner{80, []string{"h"}}})
+}
+
+func (s *S) TestUnmarshalDefaultsMerge(c *C) {
+	data := "base: &base {port: 1}\nserver:\n  hosts: [a]\n  <<: *base\n"
+	var v struct {
+		Server defaultsInner
+	}
+	err := yaml.Unmarshal([]byte(data), &v)
+	c.Assert(err, IsNil)
+	c.Assert(v.Server, DeepEquals, defaultsInner{1, []string{"a"}})
+}
+
+func (s *S) TestUnmarshalDefaultsErrors(c *C) {
+	var v struct {
+		A int `default:"abc"`
+	}
+	err := yaml.Unmarshal([]byte("\n\nb: 1"), &v)
+	c.Assert(err, ErrorMatches, "yaml: unmarshal errors:\n  line 3: cannot unmarshal !!str `abc` into int")
+
+	var w struct {
+		A int `yaml:",default=1" default:"2"`
+	}
+	c.Assert(func() { yaml.Unmarshal([]byte("b: 1"), &w) }, PanicMatches, `Duplicated default value in tag ",default=1" of type .*`)
+
+	var x struct {
+		A []int `default:"[1, *a]"`
+	}
+	c.Assert(func() { yaml.Unmarshal([]byte("b: 1"), &x) }, PanicMatches, `Invalid default value "\[1, \*a\]" for field A of type .*: anchors and aliases are not supported in default values`)
+}
+
+type validatedServer struct {
+	Name  string   `yaml:"name,required,min=2,max=8" pattern:"^[a-z]+(,[a-z]+)?$"`
+	Port  int      `yaml:"port,required,min=1,max=65535"`
+	Mode  string   `yaml:"mode,oneof=tcp udp,default=tcp"`
+	Hosts []string `yaml:"hosts,min=1"`
+	Ratio *float64 `yaml:"ratio,max=1"`
+}
+
+func (v *validatedServer) ValidateYAML() error {
+	if v.Mode == "udp" && v.Port == 53 {
+		return errors.New("udp port 53 is reserved")
+	}
+	return nil
+}
+
+func (s *S) TestUnmarshalValidation(c *C) {
+	var v validatedServer
+	err := yaml.Unmarshal([]byte("name: web\nport: 80\nhosts: [a]\nratio: 0.5\n"), &v)
+	c.Assert(err, IsNil)
+	c.Assert(v.Mode, Equals, "tcp")
+
+	var l []validatedServer
+	data := "" +
+		"- name: Web\n" +
+		"  port: 0\n" +
+		"  mode: sctp\n" +
+		"  hosts: []\n" +
+		"  ratio: 1.5\n" +
+		"- hosts: [a]\n" +
+		"- name: dns\n" +
+		"  port: 53\n" +
+		"  mode: udp\n"
+	err = yaml.Unmarshal([]byte(data), &l)
+	c.Assert(err, ErrorMatches, regexp.QuoteMeta("yaml: unmarshal errors:\n"+
+		`  line 1: "name" must match ^[a-z]+(,[a-z]+)?$, got "Web"`+"\n"+
+		`  line 2: value of "port" must be at least 1, got 0`+"\n"+
+		`  line 3: "mode" must be one of tcp, udp, got "sctp"`+"\n"+
+		`  line 4: length of "hosts" must be at least 1, got 0`+"\n"+
+		`  line 5: value of "ratio" must be at most 1, got 1.5`+"\n"+
+		`  line 6: missing required key "name" in yaml_test.validatedServer`+"\n"+
+		`  line 6: missing required key "port" in yaml_test.validatedServer`+"\n"+
+		`  line 7: udp port 53 is reserved`))
+	c.Assert(l, HasLen, 3)
+
+	// Pointers to strings may have patterns in either form.
+	var p struct {
+		A *string `yaml:",pattern=^a"`
+		B *string `pattern:"^b"`
+	}
+	err = yaml.Unmarshal([]byte("a: x\nb: y\n"), &p)
+	c.Assert(err, ErrorMatches, regexp.QuoteMeta("yaml: unmarshal errors:\n"+
+		`  line 1: "a" must match ^a, got "x"`+"\n"+
+		`  line 2: "b" must match ^b, got "y"`))
+	c.Assert(yaml.Unmarshal([]byte("a: ax\nb: by\n"), &p), IsNil)
+}
+
+func (s *S) TestUnmarshalValidationTagErrors(c *C) {
+	var v struct {
+		A bool `yaml:",min=1"`
+	}
+	c.Assert(func() { yaml.Unmarshal([]byte("a: true"), &v) }, PanicMatches, `Invalid flag "min=1" in tag ",min=1" of type .*: option min= needs a number, string, slice or map field`)
+	var w struct {
+		A int `yaml:",max=x"`
+	}
+	c.Assert(func() { yaml.Unmarshal([]byte("a: 1"), &w) }, PanicMatches, `Invalid flag "max=x" in tag ",max=x" of type .*: invalid number in option max=x`)
+	var x struct {
+		A string `yaml:",pattern=["`
+	}
+	c.Assert(func() { yaml.Unmarshal([]byte("a: 1"), &x) }, PanicMatches, `Invalid flag "pattern=\[" in tag ",pattern=\[" of type .*: error parsing regexp: .*`)
+}
+
+func (s *S) TestUnmarshalWithMetadata(c *C) {
+	type Port struct {
+		Name string
+		Port int
+	}
+	type Spec struct {
+		Replicas int `yaml:",default=1"`
+		Ports    []Port
+		Labels   map[string]Port
+		Paused   bool
+		Extra    struct{ C int } `yaml:",inline"`
+	}
+	data := "" +
+		"base: &base {name: http, port: 80}\n" +
+		"spec:\n" +
+		"  paused: false\n" +
+		"  c: 1\n" +
+		"  ports:\n" +
+		"  - *base\n" +
+		"  - <<: *base\n" +
+		"    port: 8080\n" +
+		"    proto: tcp\n" +
+		"  labels: {a: {name: x}}\n" +
+		"  unknown: 1\n"
+	var v struct {
+		Spec Spec
+	}
+	md, err := yaml.UnmarshalWithMetadata([]byte(data), &v)
+	c.Assert(err, IsNil)
+	c.Assert(v.Spec.Replicas, Equals, 1)
+	c.Assert(md.Set, DeepEquals, []string{
+		"spec",
+		"spec.paused",
+		"spec.c",
+		"spec.ports",
+		"spec.ports[0].name",
+		"spec.ports[0].port",
+		"spec.ports[1].name",
+		"spec.ports[1].port",
+		"spec.labels",
+		"spec.labels.a.name",
+	})
+	c.Assert(md.Unused, DeepEquals, []string{"base", "spec.ports[1].proto", "spec.unknown"})
+	c.Assert(md.Origins["spec.paused"], Equals, yaml.Origin{})
+	c.Assert(md.Origins["spec.ports[0].name"], Equals, yaml.Origin{Alias: true})
+	c.Assert(md.Origins["spec.ports[1].name"], Equals, yaml.Origin{Alias: true, Merge: true})
+	c.Assert(md.Origins["spec.ports[1].port"], Equals, yaml.Origin{})
+	c.Assert(md.IsSet("spec.paused"), Equals, true)
+	c.Assert(md.IsSet("spec.replicas"), Equals, false)
+}
+
+func (s *S) TestUnmarshalPosition(c *C) {
+	type Item struct {
+		Pos  yaml.Position            `yaml:",position"`
+		Keys map[string]yaml.Position `yaml:",position"`
+		Name string
+	}
+	data := "" +
+		"items:\n" +
+		"- name: a\n" +
+		"  other: 1\n" +
+		"-   name: b\n"
+	var v struct {
+		Items []Item
+	}
+	err := yaml.Unmarshal([]byte(data), &v)
+	c.Assert(err, IsNil)
+	c.Assert(v.Items, HasLen, 2)
+	c.Assert(v.Items[0].Pos, Equals, yaml.Position{Line: 2, Column: 3})
+	c.Assert(v.Items[0].Keys, DeepEquals, map[string]yaml.Position{
+		"name":  {Line: 2, Column: 3},
+		"other": {Line: 3, Column: 3},
+	})
+	c.Assert(v.Items[1].Pos, Equals, yaml.Position{Line: 4, Column: 5})
+	c.Assert(v.Items[1].Pos.String(), Equals, "line 4, column 5")
+
+	out, err := yaml.Marshal(&v.Items[0])
+	c.Assert(err, IsNil)
+	c.Assert(string(out), Equals, "name: a\n")
+
+	var w struct {
+		Pos int `yaml:",position"`
+	}
+	c.Assert(func() { yaml.Unmarshal([]byte("a: 1"), &w) }, PanicMatches, `Option ,position needs a yaml.Position or map\[string\]yaml.Position field in struct .*`)
+
+	var x struct {
+		Item  `yaml:",inline"`
+		Other int
+	}
+	c.Assert(func() { yaml.Unmarshal([]byte("a: 1"), &x) }, PanicMatches, `Option ,position can't be used in inlined struct .*Item of struct .*`)
+	var y struct {
+		Item  *Item `yaml:",inline"`
+		Other int
+	}
+	c.Assert(func() { yaml.Unmarshal([]byte("a: 1"), &y) }, PanicMatches, `Option ,position can't be used in inlined struct .*Item of struct .*`)
+}
+
+func (s *S) TestUnmarshalRawMessage(c *C) {
+	data := "" +
+		"name: héllo\n" +
+		"base: &base {x: 1}\n" +
+		"config:\n" +
+		"  a: 1   # one\n" +
+		"  b:\n" +
+		"  - x\n" +
+		"  c: |\n" +
+		"    text\n" +
+		"alias: *base\n" +
+		"quoted: 'a\n" +
+		"  b'\n" +
+		"none: ~\n"
+	var v struct {
+		Name   string
+		Config yaml.RawMessage
+		Alias  yaml.RawMessage
+		Quoted *yaml.RawMessage
+		None   yaml.RawMessage
+	}
+	err := yaml.Unmarshal([]byte(data), &v)
+	c.Assert(err, IsNil)
+	c.Assert(string(v.Config), Equals, "a: 1   # one\nb:\n- x\nc: |\n  text\n")
+	c.Assert(string(v.Alias), Equals, "&base {x: 1}")
+	c.Assert(string(*v.Quoted), Equals, "'a\n  b'")
+	c.Assert(string(v.None), Equals, "~")
+
+	var config struct {
+		A int
+		B []string
+		C string
+	}
+	err = yaml.Unmarshal(v.Config, &config)
+	c.Assert(err, IsNil)
+	c.Assert(config.A, Equals, 1)
+	c.Assert(config.B, DeepEquals, []string{"x"})
+	c.Assert(config.C, Equals, "text\n")
+}
+
+func (s *S) TestUnmarshalRawMessageAliases(c *C) {
+	var v struct {
+		A, B yaml.RawMessage
+	}
+	err := yaml.Unmarshal([]byte("a: [&x 1, *x]\nb: *x\n"), &v)
+	c.Assert(err, IsNil)
+	c.Assert(string(v.A), Equals, "[&x 1, *x]")
+	c.Assert(string(v.B), Equals, "&x 1")
+
+	v.B = nil
+	err = yaml.Unmarshal([]byte("a: &x 1\nb:\n  c: [*x]\n"), &v)
+	c.Assert(err, ErrorMatches, "yaml: unmarshal errors:\n  line 3: cannot unmarshal alias 'x' to an anchor outside the value into yaml.RawMessage")
+	c.Assert(v.B, IsNil)
+}
+
+func (s *S) TestDecoderRawMessage(c *C) {
+	dec := yaml.NewDecoder(strings.NewReader("a: ünïcode\n---\nb:\n  c: [1,\n   2]\n"))
+	var v struct {
+		A yaml.RawMessage
+		B yaml.RawMessage
+	}
+	c.Assert(dec.Decode(&v), IsNil)
+	c.Assert(string(v.A), Equals, "ünïcode")
+	c.Assert(dec.Decode(&v), IsNil)
+	c.Assert(string(v.B), Equals, "c: [1,\n 2]")
+}
+
+func (s *S) TestUnmarshalLongNonASCIIKey(c *C) {
+	// Simple keys are limited to 1024 characters, not bytes.
+	key := strings.Repeat("é", 600)
+	var v map[string]yaml.RawMessage
+	err := yaml.Unmarshal([]byte(key+": {a: é}\n"), &v)
+	c.Assert(err, IsNil)
+	c.Assert(string(v[key]), Equals, "{a: é}")
+
+	err = yaml.Unmarshal([]byte(strings.Repeat("é", 1025)+": 1\n"), &v)
+	c.Assert(err, ErrorMatches, "yaml: mapping values are not allowed in this context")
+}
+
+type shape interface {
+	area() float64
+}
+
+type circle struct {
+	Radius float64
+}
+
+func (c circle) area() float64 { return math.Pi * c.Radius * c.Radius }
+
+type square struct {
+	Kind string
+	Side float64
+}
+
+func (s *square) area() float64 { return s.Side * s.Side }
+
+var shapeType = reflect.TypeOf((*shape)(nil)).Elem()
+
+func init() {
+	yaml.RegisterUnion(shapeType, yaml.Union{
+		Key: "kind",
+		Types: map[string]reflect.Type{
+			"circle": reflect.TypeOf(circle{}),
+			"square": reflect.TypeOf(&square{}),
+		},
+	})
+}
+
+func (s *S) TestUnmarshalUnion(c *C) {
+	var v struct {
+		Shapes []shape
+		Named  map[string]shape
+	}
+	data := "" +
+		"shapes:\n" +
+		"- {kind: circle, radius: 1}\n" +
+		"- {side: 2, kind: square}\n" +
+		"- null\n" +
+		"named: {a: {kind: circle, radius: 2}}\n"
+	err := yaml.Unmarshal([]byte(data), &v)
+	c.Assert(err, IsNil)
+	c.Assert(v.Shapes, DeepEquals, []shape{circle{1}, &square{"square", 2}, nil})
+	c.Assert(v.Named, DeepEquals, map[string]shape{"a": circle{2}})
+
+	err = yaml.Unmarshal([]byte("shapes: [{radius: 1}, {kind: triangle}, {kind: [circle]}, {kind: {a: 1}}, 1]"), &v)
+	c.Assert(err, ErrorMatches, "yaml: unmarshal errors:\n"+
+		"  line 1: missing key \"kind\" to select the type of yaml_test.shape\n"+
+		"  line 1: unknown kind \"triangle\" for yaml_test.shape\n"+
+		"  line 1: kind must be a scalar to select the type of yaml_test.shape\n"+
+		"  line 1: kind must be a scalar to select the type of yaml_test.shape\n"+
+		"  line 1: cannot unmarshal !!int `1` into yaml_test.shape")
+
+	err = yaml.Unmarshal([]byte("k: &k circle\nshapes: [{kind: *k, radius: 3}]"), &v)
+	c.Assert(err, IsNil)
+	c.Assert(v.Shapes, DeepEquals, []shape{circle{3}})
+}
+
+func (s *S) TestDecoderUnion(c *C) {
+	type named interface{}
+	dec := yaml.NewDecoder(strings.NewReader("type: circle\nradius: 3\n"))
+	dec.RegisterUnion(reflect.TypeOf((*named)(nil)).Elem(), yaml.Union{
+		Key:   "type",
+		Types: map[string]reflect.Type{"circle": reflect.TypeOf(circle{})},
+	})
+	var v named
+	c.Assert(dec.Decode(&v), IsNil)
+	c.Assert(v, Equals, circle{3})
+
+	c.Assert(func() {
+		dec.RegisterUnion(shapeType, yaml.Union{
+			Key:   "kind",
+			Types: map[string]reflect.Type{"square": reflect.TypeOf(square{})},
+		})
+	}, PanicMatches, `yaml: type yaml_test.square of union yaml_test.shape for "square" doesn't implement it`)
+	c.Assert(func() {
+		yaml.RegisterUnion(shapeType, yaml.Union{
+			Key: "kind",
+			Types: map[string]reflect.Type{
+				"round":  reflect.TypeOf(circle{}),
+				"circle": reflect.TypeOf(circle{}),
+				"disc":   reflect.TypeOf(circle{}),
+			},
+		})
+	}, PanicMatches, `yaml: type yaml_test.circle of union yaml_test.shape is registered for both "circle" and "disc"`)
+}
+
+type jsonSettings struct {
+	MaxRetries int    `json:"maxRetries"`
+	Endpoint   string `json:"endpoint,omitempty"`
+	Secret     string `json:"-"`
+	Dash       string `json:"-,"`
+	Name       string `yaml:"title" json:"name"`
+	Level      jsonLevel
+	Labels     jsonLabels `json:"labels"`
+}
+
+type jsonLevel int
+
+func (l *jsonLevel) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*l = jsonLevel(len(s))
+	return nil
+}
+
+func (l jsonLevel) MarshalJSON() ([]byte, error) {
+	return json.Marshal(strings.Repeat("*", int(l)))
+}
+
+type jsonLabels struct {
+	m map[string]string
+}
+
+func (l *jsonLabels) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &l.m)
+}
+
+func (l jsonLabels) MarshalJSON() ([]byte, error) {
+	return []byte(`{"z": "last", "a": [1, "\/x"]}`), nil
+}
+
+type jsonPorts struct {
+	m map[string]int
+}
+
+func (p *jsonPorts) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &p.m)
+}
+
+func (s *S) TestDecoderJSONFallback(c *C) {
+	data := "" +
+		"maxRetries: 3\n" +
+		"endpoint: http://x\n" +
+		"secret: s\n" +
+		"-: dash\n" +
+		"title: t\n" +
+		"level: '***'\n" +
+		"labels: {a: b, 1: c}\n"
+	dec := yaml.NewDecoder(strings.NewReader(data))
+	dec.SetJSONFallback(true)
+	var v jsonSettings
+	c.Assert(dec.Decode(&v), IsNil)
+	c.Assert(v.MaxRetries, Equals, 3)
+	c.Assert(v.Endpoint, Equals, "http://x")
+	c.Assert(v.Secret, Equals, "")
+	c.Assert(v.Dash, Equals, "dash")
+	c.Assert(v.Name, Equals, "t")
+	c.Assert(v.Level, Equals, jsonLevel(3))
+	c.Assert(v.Labels.m, DeepEquals, map[string]string{"a": "b", "1": "c"})
+
+	dec = yaml.NewDecoder(strings.NewReader("level: 3\n"))
+	dec.SetJSONFallback(true)
+	c.Assert(dec.Decode(&v), ErrorMatches, "json: cannot unmarshal number into Go value of type string")
+
+	// Numbers reach UnmarshalJSON as JSON numbers regardless of the
+	// options for interface{} values.
+	dec = yaml.NewDecoder(strings.NewReader("ports: {http: 8080}\n"))
+	dec.SetJSONFallback(true)
+	dec.SetUseNumber(true)
+	var p struct {
+		Ports jsonPorts
+	}
+	c.Assert(dec.Decode(&p), IsNil)
+	c.Assert(p.Ports.m, DeepEquals, map[string]int{"http": 8080})
+
+	// Without the fallback, json tags and methods are ignored.
+	var w jsonSettings
+	err := yaml.Unmarshal([]byte(data), &w)
+	c.Assert(err, ErrorMatches, "yaml: unmarshal errors:\n  line 6: cannot unmarshal !!str `\\*\\*\\*` into yaml_test.jsonLevel")
+	c.Assert(w.MaxRetries, Equals, 0)
+	c.Assert(w.Secret, Equals, "s")
+}
+
+var namingTests = []struct {
+	field                      string
+	lower, camel, snake, kebab string
+}{
+	{"Name", "name", "name", "name", "name"},
+	{"MaxRetries", "maxretries", "maxRetries", "max_retries", "max-retries"},
+	{"HTTPServer", "httpserver", "httpServer", "http_server", "http-server"},
+	{"UserID", "userid", "userId", "user_id", "user-id"},
+	{"Level2Cache", "level2cache", "level2Cache", "level2_cache", "level2-cache"},
+}
+
+func (s *S) TestNamingStrategies(c *C) {
+	for _, test := range namingTests {
+		for _, t := range []struct {
+			naming *yaml.NamingStrategy
+			key    string
+		}{
+			{yaml.LowerCase, test.lower},
+			{yaml.CamelCase, test.camel},
+			{yaml.SnakeCase, test.snake},
+			{yaml.KebabCase, test.kebab},
+		} {
+			st := reflect.StructOf([]reflect.StructField{{Name: test.field, Type: reflect.TypeOf(0)}})
+			v := reflect.New(st)
+			dec := yaml.NewDecoder(strings.NewReader(t.key + ": 1"))
+			dec.SetNamingStrategy(t.naming)
+			c.Assert(dec.Decode(v.Interface()), IsNil)
+			c.Assert(v.Elem().Field(0).Interface(), Equals, 1, Commentf("field %s, key %s", test.field, t.key))
+		}
+	}
+}
+
+func (s *S) TestDecoderNamingStrategy(c *C) {
+	type T struct {
+		MaxRetries int
+		Tagged     int `yaml:"Tagged"`
+	}
+	data := "max_retries: 1\nmaxretries: 2\nTagged: 3\n"
+	var v T
+	dec := yaml.NewDecoder(strings.NewReader(data))
+	dec.SetNamingStrategy(yaml.SnakeCase)
+	c.Assert(dec.Decode(&v), IsNil)
+	c.Assert(v, Equals, T{1, 3})
+
+	// The default strategy is unaffected by the cached snake case info.
+	v = T{}
+	c.Assert(yaml.Unmarshal([]byte(data), &v), IsNil)
+	c.Assert(v, Equals, T{2, 3})
+
+	custom := yaml.NewNamingStrategy(func(name string) string { return "x" + name })
+	v = T{}
+	dec = yaml.NewDecoder(strings.NewReader("xMaxRetries: 4"))
+	dec.SetNamingStrategy(custom)
+	c.Assert(dec.Decode(&v), IsNil)
+	c.Assert(v, Equals, T{4, 0})
+}
+
+type embedBase struct {
+	ID   int
+	Name string
+}
+
+type EmbedMeta struct {
+	Name string `yaml:"name"`
+	Note string
+}
+
+type embedOuter struct {
+	embedBase
+	*EmbedMeta
+	Note string
+}
+
+type embedX1 struct{ X int }
+type embedX2 struct{ X int }
+
+type embedPair struct {
+	embedX1
+	embedX2
+}
+
+type embedConflicts struct {
+	embedPair
+	Other struct {
+		X1 embedX1 `yaml:",inline"`
+	} `yaml:",inline"`
+}
+
+type EmbedNode struct {
+	*EmbedNode
+	Value int
+}
+
+func (s *S) TestDecoderAutoInline(c *C) {
+	data := "id: 1\nname: n\nnote: x\n"
+	var v embedOuter
+	dec := yaml.NewDecoder(strings.NewReader(data))
+	dec.SetAutoInline(true)
+	c.Assert(dec.Decode(&v), IsNil)
+	c.Assert(v, DeepEquals, embedOuter{
+		embedBase: embedBase{ID: 1},
+		EmbedMeta: &EmbedMeta{Name: "n"},
+		Note:      "x",
+	})
+
+	// Nil embedded pointers are only allocated when needed.
+	v = embedOuter{}
+	dec = yaml.NewDecoder(strings.NewReader("id: 2\n"))
+	dec.SetAutoInline(true)
+	c.Assert(dec.Decode(&v), IsNil)
+	c.Assert(v, DeepEquals, embedOuter{embedBase: embedBase{ID: 2}})
+
+	// Keys shared at the same depth are ambiguous, even if a field
+	// at that depth in another struct has the key alone.
+	var p embedPair
+	dec = yaml.NewDecoder(strings.NewReader("x: 1\n"))
+	dec.SetAutoInline(true)
+	c.Assert(dec.Decode(&p), IsNil)
+	c.Assert(p, Equals, embedPair{})
+	var cv embedConflicts
+	dec = yaml.NewDecoder(strings.NewReader("x: 1\n"))
+	dec.SetAutoInline(true)
+	c.Assert(dec.Decode(&cv), IsNil)
+	c.Assert(cv, Equals, embedConflicts{})
+
+	var n EmbedNode
+	dec = yaml.NewDecoder(strings.NewReader("value: 1\n"))
+	dec.SetAutoInline(true)
+	c.Assert(dec.Decode(&n), IsNil)
+	c.Assert(n, Equals, EmbedNode{Value: 1})
+
+	// Without the option, exported embedded structs have a key of
+	// their own, and private ones are skipped.
+	v = embedOuter{}
+	c.Assert(yaml.Unmarshal([]byte("id: 1\nembedmeta: {name: n}\n"), &v), IsNil)
+	c.Assert(v, DeepEquals, embedOuter{EmbedMeta: &EmbedMeta{Name: "n"}})
+}
+
+type AliasedInner struct {
+	Timeout int `yaml:"timeout,alias=wait"`
+}
+
+type aliasedConfig struct {
+	Port         int    `yaml:"port,alias=listen_port,alias=listen"`
+	Host         string `yaml:"host"`
+	HostName     string `yaml:"Host"`
+	AliasedInner `yaml:",inline"`
+}
+
+func (s *S) TestUnmarshalAliases(c *C) {
+	var v aliasedConfig
+	err := yaml.Unmarshal([]byte("listen_port: 80\nwait: 5\nhost: a\n"), &v)
+	c.Assert(err, IsNil)
+	c.Assert(v.Port, Equals, 80)
+	c.Assert(v.Timeout, Equals, 5)
+	c.Assert(v.Host, Equals, "a")
+
+	var warnings []string
+	dec := yaml.NewDecoder(strings.NewReader("listen: 8080\nPORT: 1\nWait: 2\nHOST: x\nHost: y\n"))
+	dec.SetWarningHandler(func(msg string) { warnings = append(warnings, msg) })
+	dec.SetCaseInsensitive(true)
+	v = aliasedConfig{}
+	md, err := dec.DecodeWithMetadata(&v)
+	c.Assert(err, IsNil)
+	c.Assert(v.Port, Equals, 1)
+	c.Assert(v.Timeout, Equals, 2)
+	c.Assert(v.Host, Equals, "")
+	c.Assert(v.HostName, Equals, "y")
+	c.Assert(warnings, DeepEquals, []string{
+		`line 1: key "listen" is deprecated, use "port" instead`,
+		`line 3: key "Wait" is deprecated, use "timeout" instead`,
+	})
+	c.Assert(md.Set, DeepEquals, []string{"port", "timeout", "Host"})
+	c.Assert(md.Unused, DeepEquals, []string{"HOST"})
+
+	// Without case-insensitive matching, only exact keys match.
+	v = aliasedConfig{}
+	c.Assert(yaml.Unmarshal([]byte("PORT: 1\nListen: 2\n"), &v), IsNil)
+	c.Assert(v.Port, Equals, 0)
+}
+
+func (s *S) TestUnmarshalAliasErrors(c *C) {
+	var v struct {
+		A int `yaml:"a,alias=b"`
+		B int `yaml:"b"`
+	}
+	c.Assert(func() { yaml.Unmarshal([]byte("a: 1"), &v) }, PanicMatches, `Alias 'b' duplicates a key in struct .*`)
+	var w struct {
+		A int `yaml:"a,alias=c"`
+		B int `yaml:"b,alias=c"`
+	}
+	c.Assert(func() { yaml.Unmarshal([]byte("a: 1"), &w) }, PanicMatches, `Duplicated alias 'c' in struct .*`)
+	var x struct {
+		A AliasedInner `yaml:",inline,alias=c"`
+	}
+	c.Assert(func() { yaml.Unmarshal([]byte("a: 1"), &x) }, PanicMatches, `Option ,inline can't have aliases in struct .*`)
+}
+
+func (s *S) TestDecoderUseNumber(c *C) {
+	data := "a: 123456789012345678901234567890\nb: 1.000000000000000000001\nc: 0x1F\nd: text\ne: -.inf\nf: 1_000\n"
+	var v map[string]interface{}
+	dec := yaml.NewDecoder(strings.NewReader(data))
+	dec.SetUseNumber(true)
+	c.Assert(dec.Decode(&v), IsNil)
+	c.Assert(v, DeepEquals, map[string]interface{}{
+		"a": yaml.Number("123456789012345678901234567890"),
+		"b": yaml.Number("1.000000000000000000001"),
+		"c": yaml.Number("0x1F"),
+		"d": "text",
+		"e": yaml.Number("-.inf"),
+		"f": yaml.Number("1_000"),
+	})
+
+	i, err := yaml.Number("0x1F").Int64()
+	c.Assert(err, IsNil)
+	c.Assert(i, Equals, int64(31))
+	i, err = yaml.Number("1_000").Int64()
+	c.Assert(err, IsNil)
+	c.Assert(i, Equals, int64(1000))
+	_, err = yaml.Number("123456789012345678901234567890").Int64()
+	c.Assert(err, ErrorMatches, "yaml: number 123456789012345678901234567890 overflows int64")
+	_, err = yaml.Number("1.5").Int64()
+	c.Assert(err, ErrorMatches, `yaml: number "1.5" is not an integer`)
+
+	bi, err := yaml.Number("123456789012345678901234567890").BigInt()
+	c.Assert(err, IsNil)
+	c.Assert(bi.String(), Equals, "123456789012345678901234567890")
+	bf, err := yaml.Number("1.000000000000000000001").BigFloat()
+	c.Assert(err, IsNil)
+	c.Assert(bf.Text('g', -1), Equals, "1.000000000000000000001")
+	_, err = yaml.Number(".nan").BigFloat()
+	c.Assert(err, ErrorMatches, "yaml: cannot represent .nan as a big.Float")
+
+	f, err := yaml.Number("-.inf").Float64()
+	c.Assert(err, IsNil)
+	c.Assert(math.IsInf(f, -1), Equals, true)
+	f, err = yaml.Number("0x10").Float64()
+	c.Assert(err, IsNil)
+	c.Assert(f, Equals, 16.0)
+	_, err = yaml.Number("text").Float64()
+	c.Assert(err, ErrorMatches, `yaml: invalid number "text"`)
+
+	// Number fields take integers and floats only.
+	var t struct{ N, M yaml.Number }
+	err = yaml.Unmarshal([]byte("n: 1e3\nm: abc\n"), &t)
+	c.Assert(err, ErrorMatches, "yaml: unmarshal errors:\n  line 2: cannot unmarshal !!str `abc` into yaml.Number")
+	c.Assert(t.N, Equals, yaml.Number("1e3"))
+}
+
+func (s *S) TestDecoderUseInt64(c *C) {
+	var v []interface{}
+	dec := yaml.NewDecoder(strings.NewReader("[1, -2, 18446744073709551615, 1.5]"))
+	dec.SetUseInt64(true)
+	c.Assert(dec.Decode(&v), IsNil)
+	c.Assert(v, DeepEquals, []interface{}{int64(1), int64(-2), uint64(18446744073709551615), 1.5})
+}
+
+func (s *S) TestOrderedMap(c *C) {
+	m := yaml.NewOrderedMap(yaml.MapItem{"b", 1}, yaml.MapItem{"a", 2}, yaml.MapItem{"b", 3})
+	c.Assert(m.Len(), Equals, 2)
+	c.Assert(m.Keys(), DeepEquals, []interface{}{"b", "a"})
+	m.Set("c", 4)
+	m.Set("a", 5)
+	v, ok := m.Get("a")
+	c.Assert(ok, Equals, true)
+	c.Assert(v, Equals, 5)
+	m.Delete("b")
+	m.Delete("x")
+	_, ok = m.Get("b")
+	c.Assert(ok, Equals, false)
+	c.Assert(m.Items(), DeepEquals, yaml.MapSlice{{"a", 5}, {"c", 4}})
+	v, ok = m.Get("c")
+	c.Assert(ok, Equals, true)
+	c.Assert(v, Equals, 4)
+
+	var zero yaml.OrderedMap
+	_, ok = zero.Get("a")
+	c.Assert(ok, Equals, false)
+	zero.Delete("a")
+	c.Assert(zero.Len(), Equals, 0)
+}
+
+func (s *S) TestUnmarshalOrderedMap(c *C) {
+	data := "<<: {d: 0}\nb: 1\na: {u: 2, x: [1, {q: 3}]}\n3: c\n"
+	var m yaml.OrderedMap
+	c.Assert(yaml.Unmarshal([]byte(data), &m), IsNil)
+	c.Assert(m.Keys(), DeepEquals, []interface{}{"d", "b", "a", 3})
+	a, _ := m.Get("a")
+	c.Assert(a, DeepEquals, yaml.NewOrderedMap(
+		yaml.MapItem{"u", 2},
+		yaml.MapItem{"x", []interface{}{1, yaml.NewOrderedMap(yaml.MapItem{"q", 3})}},
+	))
+
+	// Ordered maps may be at any depth, and don't change how other
+	// values decode.
+	var t struct {
+		M yaml.OrderedMap
+		P *yaml.OrderedMap
+		S map[string]yaml.OrderedMap
+		I interface{}
+	}
+	data = "m: {z: 1, a: {}}\np: {e: 2}\ns: {k: {x: 3, w: 4}}\ni: {v: 5}\n"
+	c.Assert(yaml.Unmarshal([]byte(data), &t), IsNil)
+	c.Assert(t.M.Keys(), DeepEquals, []interface{}{"z", "a"})
+	inner, _ := t.M.Get("a")
+	c.Assert(inner, DeepEquals, &yaml.OrderedMap{})
+	c.Assert(t.P.Keys(), DeepEquals, []interface{}{"e"})
+	sk := t.S["k"]
+	c.Assert(sk.Keys(), DeepEquals, []interface{}{"x", "w"})
+	c.Assert(t.I, DeepEquals, map[interface{}]interface{}{"v": 5})
+
+	err := yaml.Unmarshal([]byte("? [1]\n: a\n"), &m)
+	c.Assert(err, ErrorMatches, `yaml: invalid map key: \[\]interface \{\}\{1\}`)
+}
+
+func (s *S) TestDecoderMapType(c *C) {
+	data := "b: [{d: 1}]\n1.0: {c: ~}\n~: x\n"
+	tests := []struct {
+		maps yaml.MapType
+		want interface{}
+	}{{
+		yaml.InterfaceMaps,
+		map[interface{}]interface{}{
+			"b": []interface{}{map[interface{}]interface{}{"d": 1}},
+			1.0: map[interface{}]interface{}{"c": nil},
+			nil: "x",
+		},
+	}, {
+		yaml.StringMaps,
+		map[string]interface{}{
+			"b":   []interface{}{map[string]interface{}{"d": 1}},
+			"1.0": map[string]interface{}{"c": nil},
+			"":    "x",
+		},
+	}, {
+		yaml.MapSlices,
+		yaml.MapSlice{
+			{"b", []interface{}{yaml.MapSlice{{"d", 1}}}},
+			{1.0, yaml.MapSlice{{"c", nil}}},
+			{nil, "x"},
+		},
+	}, {
+		yaml.OrderedMaps,
+		yaml.NewOrderedMap(
+			yaml.MapItem{"b", []interface{}{yaml.NewOrderedMap(yaml.MapItem{"d", 1})}},
+			yaml.MapItem{1.0, yaml.NewOrderedMap(yaml.MapItem{"c", nil})},
+			yaml.MapItem{nil, "x"},
+		),
+	}}
+	for _, test := range tests {
+		var v interface{}
+		dec := yaml.NewDecoder(strings.NewReader(data))
+		dec.SetMapType(test.maps)
+		c.Assert(dec.Decode(&v), IsNil)
+		c.Assert(v, DeepEquals, test.want, Commentf("map type %d", test.maps))
+	}
+
+	// Mappings in typed values keep their own representation.
+	var t struct {
+		M map[interface{}]interface{}
+		I interface{}
+	}
+	dec := yaml.NewDecoder(strings.NewReader("m: {a: {b: 1}}\ni: {a: {b: 1}}\n"))
+	dec.SetMapType(yaml.StringMaps)
+	c.Assert(dec.Decode(&t), IsNil)
+	c.Assert(t.M, DeepEquals, map[interface{}]interface{}{"a": map[interface{}]interface{}{"b": 1}})
+	c.Assert(t.I, DeepEquals, map[string]interface{}{"a": map[string]interface{}{"b": 1}})
+
+	var v interface{}
+	dec = yaml.NewDecoder(strings.NewReader("a: 1\n? [1]\n: 2\n"))
+	dec.SetMapType(yaml.StringMaps)
+	c.Assert(dec.Decode(&v), ErrorMatches, "yaml: unmarshal errors:\n  line 2: cannot unmarshal !!seq into string")
+	c.Assert(v, DeepEquals, map[string]interface{}{"a": 1})
+}
+
+func (s *S) TestConvertMaps(c *C) {
+	v := map[interface{}]interface{}{
+		"b": []interface{}{map[string]interface{}{"y": 1, "x": 2}},
+		"a": yaml.MapSlice{{"d", 3}, {"c", yaml.NewOrderedMap(yaml.MapItem{"f", 4})}, {"d", 5}},
+		2:   "two",
+	}
+	slices, err := yaml.ConvertMaps(v, yaml.MapSlices)
+	c.Assert(err, IsNil)
+	c.Assert(slices, DeepEquals, yaml.MapSlice{
+		{2, "two"},
+		{"a", yaml.MapSlice{{"d", 3}, {"c", yaml.MapSlice{{"f", 4}}}, {"d", 5}}},
+		{"b", []interface{}{yaml.MapSlice{{"x", 2}, {"y", 1}}}},
+	})
+
+	ordered, err := yaml.ConvertMaps(slices, yaml.OrderedMaps)
+	c.Assert(err, IsNil)
+	c.Assert(ordered, DeepEquals, yaml.NewOrderedMap(
+		yaml.MapItem{2, "two"},
+		yaml.MapItem{"a", yaml.NewOrderedMap(yaml.MapItem{"d", 5}, yaml.MapItem{"c", yaml.NewOrderedMap(yaml.MapItem{"f", 4})})},
+		yaml.MapItem{"b", []interface{}{yaml.NewOrderedMap(yaml.MapItem{"x", 2}, yaml.MapItem{"y", 1})}},
+	))
+
+	generic, err := yaml.ConvertMaps(ordered, yaml.InterfaceMaps)
+	c.Assert(err, IsNil)
+	c.Assert(generic, DeepEquals, map[interface{}]interface{}{
+		"b": []interface{}{map[interface{}]interface{}{"y": 1, "x": 2}},
+		"a": map[interface{}]interface{}{"d": 5, "c": map[interface{}]interface{}{"f": 4}},
+		2:   "two",
+	})
+
+	scalar, err := yaml.ConvertMaps("a", yaml.StringMaps)
+	c.Assert(err, IsNil)
+	c.Assert(scalar, Equals, "a")
+
+	_, err = yaml.ConvertMaps(yaml.MapSlice{{[]interface{}{1}, 2}}, yaml.InterfaceMaps)
+	c.Assert(err, ErrorMatches, `yaml: invalid map key: \[\]interface \{\}\{1\}`)
+}
+
+func (s *S) TestUnmarshalTextKeys(c *C) {
+	var m map[hexKey]string
+	c.Assert(yaml.Unmarshal([]byte("10: a\n1a: b\n'ff': c\n"), &m), IsNil)
+	c.Assert(m, DeepEquals, map[hexKey]string{16: "a", 26: "b", 255: "c"})
+
+	var v struct {
+		M map[hexKey]int `yaml:",inline"`
+	}
+	c.Assert(yaml.Unmarshal([]byte("10: 10\n"), &v), IsNil)
+	c.Assert(v.M, DeepEquals, map[hexKey]int{16: 10})
+
+	// Values are still unmarshalled from what they resolve to.
+	var h hexKey
+	c.Assert(yaml.Unmarshal([]byte("10"), &h), IsNil)
+	c.Assert(h, Equals, hexKey(10))
+}
+
+func (s *S) TestUnmarshalSlashEscape(c *C) {
+	// The \/ escape of YAML 1.2 and JSON is only known in double quotes.
+	var v map[string]string
+	err := yaml.Unmarshal([]byte(`{a: "x\/y", b: 'x\/y', c: x\/y}`), &v)
+	c.Assert(err, IsNil)
+	c.Assert(v, DeepEquals, map[string]string{"a": "x/y", "b": `x\/y`, "c": `x\/y`})
+}
+
 func (s *S) TestUnmarshalSliceOnPreset(c *C) {
 	// Issue #48.
 	v := struct{ A []int }{[]int{1}}
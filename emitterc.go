@@ -2,6 +2,7 @@ package yaml
 
 import (
 	"bytes"
+	"unicode/utf8"
 )
 
 // Flush the buffer if needed.
@@ -151,6 +152,13 @@ func yaml_emitter_need_more_events(emitter *yaml_emitter_t) bool {
 	default:
 		return false
 	}
+	// [Go] Collections that may be emitted in flow style are needed
+	// whole to tell whether they fit in the line.
+	if emitter.auto_flow && accumulate > 1 {
+		if _, complete := yaml_emitter_check_flow_fit(emitter); !complete {
+			return true
+		}
+	}
 	if len(emitter.events)-emitter.events_head > accumulate {
 		return false
 	}
@@ -662,6 +670,8 @@ func yaml_emitter_emit_node(emitter *yaml_emitter_t, event *yaml_event_t,
 		return yaml_emitter_emit_sequence_start(emitter, event)
 	case yaml_MAPPING_START_EVENT:
 		return yaml_emitter_emit_mapping_start(emitter, event)
+	case yaml_RAW_EVENT:
+		return yaml_emitter_emit_raw(emitter, event)
 	default:
 		return yaml_emitter_set_emitter_error(emitter,
 			"expected SCALAR, SEQUENCE-START, MAPPING-START, or ALIAS")
@@ -703,6 +713,31 @@ func yaml_emitter_emit_scalar(emitter *yaml_emitter_t, event *yaml_event_t) bool
 	return true
 }
 
+// [Go] Expect RAW.
+func yaml_emitter_emit_raw(emitter *yaml_emitter_t, event *yaml_event_t) bool {
+	block := event.mapping_style() == yaml_BLOCK_MAPPING_STYLE || event.sequence_style() == yaml_BLOCK_SEQUENCE_STYLE
+	if block && emitter.flow_level > 0 {
+		return yaml_emitter_set_emitter_error(emitter, "cannot emit a raw block collection in flow context")
+	}
+	if !yaml_emitter_increase_indent(emitter, !emitter.root_context, false) {
+		return false
+	}
+	if block && emitter.mapping_context {
+		// A block collection can't start on the line of its key.
+		if !yaml_emitter_write_indent(emitter) {
+			return false
+		}
+	}
+	if !yaml_emitter_write_raw(emitter, event.value) {
+		return false
+	}
+	emitter.indent = emitter.indents[len(emitter.indents)-1]
+	emitter.indents = emitter.indents[:len(emitter.indents)-1]
+	emitter.state = emitter.states[len(emitter.states)-1]
+	emitter.states = emitter.states[:len(emitter.states)-1]
+	return true
+}
+
 // Expect SEQUENCE-START.
 func yaml_emitter_emit_sequence_start(emitter *yaml_emitter_t, event *yaml_event_t) bool {
 	if !yaml_emitter_process_anchor(emitter) {
@@ -712,7 +747,7 @@ func yaml_emitter_emit_sequence_start(emitter *yaml_emitter_t, event *yaml_event
 		return false
 	}
 	if emitter.flow_level > 0 || emitter.canonical || event.sequence_style() == yaml_FLOW_SEQUENCE_STYLE ||
-		yaml_emitter_check_empty_sequence(emitter) {
+		yaml_emitter_check_empty_sequence(emitter) || yaml_emitter_fits_flow(emitter) {
 		emitter.state = yaml_EMIT_FLOW_SEQUENCE_FIRST_ITEM_STATE
 	} else {
 		emitter.state = yaml_EMIT_BLOCK_SEQUENCE_FIRST_ITEM_STATE
@@ -729,7 +764,7 @@ func yaml_emitter_emit_mapping_start(emitter *yaml_emitter_t, event *yaml_event_
 		return false
 	}
 	if emitter.flow_level > 0 || emitter.canonical || event.mapping_style() == yaml_FLOW_MAPPING_STYLE ||
-		yaml_emitter_check_empty_mapping(emitter) {
+		yaml_emitter_check_empty_mapping(emitter) || yaml_emitter_fits_flow(emitter) {
 		emitter.state = yaml_EMIT_FLOW_MAPPING_FIRST_KEY_STATE
 	} else {
 		emitter.state = yaml_EMIT_BLOCK_MAPPING_FIRST_KEY_STATE
@@ -737,6 +772,58 @@ func yaml_emitter_emit_mapping_start(emitter *yaml_emitter_t, event *yaml_event_
 	return true
 }
 
+// [Go] Check if the collection at the head of the event queue is emitted
+// in flow style because it fits in the line.
+func yaml_emitter_fits_flow(emitter *yaml_emitter_t) bool {
+	if !emitter.auto_flow {
+		return false
+	}
+	fits, _ := yaml_emitter_check_flow_fit(emitter)
+	return fits
+}
+
+// [Go] Check if the collection at the head of the event queue holds only
+// scalars and aliases, and fits in the rest of the line in flow style.
+// The check is complete if there are enough events in the queue to tell.
+func yaml_emitter_check_flow_fit(emitter *yaml_emitter_t) (fits, complete bool) {
+	// The space before the collection and its brackets.
+	width := emitter.column + 3
+	for i := emitter.events_head + 1; i < len(emitter.events); i++ {
+		event := &emitter.events[i]
+		if i > emitter.events_head+1 {
+			// The separator of items, or of keys and values.
+			width += 2
+		}
+		switch event.typ {
+		case yaml_SEQUENCE_END_EVENT, yaml_MAPPING_END_EVENT:
+			return width-2 <= emitter.best_width, true
+		case yaml_ALIAS_EVENT:
+			width += 1 + len(event.anchor)
+		case yaml_SCALAR_EVENT:
+			style := event.scalar_style()
+			if len(event.anchor) > 0 || !event.implicit && !event.quoted_implicit ||
+				style == yaml_LITERAL_SCALAR_STYLE || style == yaml_FOLDED_SCALAR_STYLE {
+				return false, true
+			}
+			if !yaml_emitter_analyze_scalar(emitter, event.value) || emitter.scalar_data.multiline {
+				return false, true
+			}
+			width += utf8.RuneCount(event.value)
+			if style > yaml_PLAIN_SCALAR_STYLE || len(event.value) == 0 || !emitter.scalar_data.flow_plain_allowed {
+				// Quotes, and an estimate of the escapes within them.
+				width += 2 + bytes.Count(event.value, []byte{'\''}) + bytes.Count(event.value, []byte{'"'}) +
+					bytes.Count(event.value, []byte{'\\'})
+			}
+		default:
+			return false, true
+		}
+		if width > emitter.best_width {
+			return false, true
+		}
+	}
+	return false, false
+}
+
 // Check if the document content is an empty scalar.
 func yaml_emitter_check_empty_document(emitter *yaml_emitter_t) bool {
 	return false // [Go] Huh?
@@ -1218,6 +1305,39 @@ func yaml_emitter_write_indent(emitter *yaml_emitter_t) bool {
 	return true
 }
 
+// [Go] Write the lines of value at the current indentation.
+func yaml_emitter_write_raw(emitter *yaml_emitter_t, value []byte) bool {
+	if !emitter.whitespace {
+		if !put(emitter, ' ') {
+			return false
+		}
+	}
+	for i := 0; i < len(value); {
+		if value[i] == '\n' {
+			if !put_break(emitter) {
+				return false
+			}
+			i++
+			emitter.whitespace = true
+			emitter.indention = true
+			continue
+		}
+		if emitter.column == 0 {
+			for emitter.column < emitter.indent {
+				if !put(emitter, ' ') {
+					return false
+				}
+			}
+		}
+		if !write(emitter, value, &i) {
+			return false
+		}
+		emitter.whitespace = false
+		emitter.indention = false
+	}
+	return true
+}
+
 func yaml_emitter_write_indicator(emitter *yaml_emitter_t, indicator []byte, need_whitespace, is_whitespace, is_indention bool) bool {
 	if need_whitespace && !emitter.whitespace {
 		if !put(emitter, ' ') {
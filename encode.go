@@ -2,7 +2,9 @@ package yaml
 
 import (
 	"encoding"
+	"errors"
 	"fmt"
+	"io"
 	"reflect"
 	"regexp"
 	"sort"
@@ -12,10 +14,41 @@ import (
 )
 
 type encoder struct {
-	emitter yaml_emitter_t
-	event   yaml_event_t
-	out     []byte
-	flow    bool
+	emitter  yaml_emitter_t
+	event    yaml_event_t
+	out      []byte
+	flow     bool
+	doneInit bool
+
+	// field is the struct field that the next node emitted comes from,
+	// if any, whose tag may set the format of scalars.
+	field *fieldInfo
+
+	// discriminator is written as the first key of the next mapping,
+	// which must be the next node emitted.
+	discriminator *MapItem
+
+	encodeOptions
+}
+
+// encodeOptions holds the settings of an Encoder.
+type encodeOptions struct {
+	codecs    codecMap
+	resolvers resolverList
+	unions    unionMap
+
+	// omitEmpty is whether all struct fields are omitted when empty,
+	// as if they had the omitempty flag.
+	omitEmpty bool
+
+	// quoting and quotes decide which strings are quoted, and how.
+	quoting QuotingPolicy
+	quotes  QuoteStyle
+
+	// keyOrder orders the keys of maps, in natural order if nil.
+	keyOrder KeyOrder
+
+	structOptions
 }
 
 func newEncoder() (e *encoder) {
@@ -23,16 +56,27 @@ func newEncoder() (e *encoder) {
 	e.must(yaml_emitter_initialize(&e.emitter))
 	yaml_emitter_set_output_string(&e.emitter, &e.out)
 	yaml_emitter_set_unicode(&e.emitter, true)
+	return e
+}
+
+func newEncoderWithWriter(w io.Writer) (e *encoder) {
+	e = &encoder{}
+	e.must(yaml_emitter_initialize(&e.emitter))
+	yaml_emitter_set_output_file(&e.emitter, w)
+	yaml_emitter_set_unicode(&e.emitter, true)
+	return e
+}
+
+func (e *encoder) init() {
+	if e.doneInit {
+		return
+	}
 	e.must(yaml_stream_start_event_initialize(&e.event, yaml_UTF8_ENCODING))
 	e.emit()
-	e.must(yaml_document_start_event_initialize(&e.event, nil, nil, true))
-	e.emit()
-	return e
+	e.doneInit = true
 }
 
 func (e *encoder) finish() {
-	e.must(yaml_document_end_event_initialize(&e.event, true))
-	e.emit()
 	e.emitter.open_ended = false
 	e.must(yaml_stream_end_event_initialize(&e.event))
 	e.emit()
@@ -43,6 +87,10 @@ func (e *encoder) destroy() {
 }
 
 func (e *encoder) emit() {
+	if e.discriminator != nil && e.event.typ != yaml_MAPPING_START_EVENT {
+		failf("cannot marshal %s key into a value that isn't a mapping", e.discriminator.Key)
+	}
+	e.field = nil
 	// This will internally delete the e.event value.
 	if !yaml_emitter_emit(&e.emitter, &e.event) && e.event.typ != yaml_DOCUMENT_END_EVENT && e.event.typ != yaml_STREAM_END_EVENT {
 		e.must(false)
@@ -59,13 +107,47 @@ func (e *encoder) must(ok bool) {
 	}
 }
 
+func (e *encoder) marshalDoc(tag string, in reflect.Value) {
+	e.init()
+	e.must(yaml_document_start_event_initialize(&e.event, nil, nil, true))
+	e.emit()
+	e.marshal(tag, in)
+	e.must(yaml_document_end_event_initialize(&e.event, true))
+	e.emit()
+}
+
 func (e *encoder) marshal(tag string, in reflect.Value) {
 	if !in.IsValid() {
 		e.nilv()
 		return
 	}
+	if in.Type() == rawMessageType {
+		e.rawv(in)
+		return
+	}
+	if in.Kind() == reflect.Interface && !in.IsNil() {
+		if u := e.unions.lookup(in.Type()); u != nil {
+			e.unionv(tag, in, u)
+			return
+		}
+	}
 	iface := in.Interface()
-	if m, ok := iface.(Marshaler); ok {
+	if marshal := e.codecs.marshalFunc(in.Type()); marshal != nil {
+		v, err := marshal(iface)
+		if err != nil {
+			fail(err)
+		}
+		if v == nil {
+			e.nilv()
+			return
+		}
+		in = reflect.ValueOf(v)
+	} else if in.Kind() == reflect.Ptr && !in.IsNil() && e.codecs.marshalFunc(in.Type().Elem()) != nil {
+		// The codec of the pointed-to type takes precedence over
+		// the interfaces implemented by the pointer.
+		e.marshal(tag, in.Elem())
+		return
+	} else if m, ok := iface.(Marshaler); ok {
 		v, err := m.MarshalYAML()
 		if err != nil {
 			fail(err)
@@ -75,6 +157,13 @@ func (e *encoder) marshal(tag string, in reflect.Value) {
 			return
 		}
 		in = reflect.ValueOf(v)
+	} else if m, ok := e.jsonMarshaler(in); ok {
+		v := marshalJSON(m)
+		if v == nil {
+			e.nilv()
+			return
+		}
+		in = reflect.ValueOf(v)
 	} else if m, ok := iface.(encoding.TextMarshaler); ok {
 		text, err := m.MarshalText()
 		if err != nil {
@@ -98,7 +187,11 @@ func (e *encoder) marshal(tag string, in reflect.Value) {
 			e.marshal(tag, in.Elem())
 		}
 	case reflect.Struct:
-		e.structv(tag, in)
+		if in.Type() == orderedMapType {
+			e.orderedv(tag, in)
+		} else {
+			e.structv(tag, in)
+		}
 	case reflect.Slice:
 		if in.Type().Elem() == mapItemType {
 			e.itemsv(tag, in)
@@ -106,10 +199,14 @@ func (e *encoder) marshal(tag string, in reflect.Value) {
 			e.slicev(tag, in)
 		}
 	case reflect.String:
-		e.stringv(tag, in)
+		if in.Type() == numberType {
+			e.numberv(tag, in)
+		} else {
+			e.stringv(tag, in, reflect.TypeOf(iface))
+		}
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		if in.Type() == durationType {
-			e.stringv(tag, reflect.ValueOf(iface.(time.Duration).String()))
+			e.stringv(tag, reflect.ValueOf(iface.(time.Duration).String()), durationType)
 		} else {
 			e.intv(tag, in)
 		}
@@ -125,19 +222,73 @@ func (e *encoder) marshal(tag string, in reflect.Value) {
 }
 
 func (e *encoder) mapv(tag string, in reflect.Value) {
+	if item := e.discriminator; item != nil {
+		for _, k := range in.MapKeys() {
+			if holdsString(k, item.Key.(string)) {
+				e.ownDiscriminator(in.MapIndex(k))
+				break
+			}
+		}
+	}
 	e.mappingv(tag, func() {
-		keys := keyList(in.MapKeys())
-		sort.Sort(keys)
-		for _, k := range keys {
+		for _, k := range e.sortedKeys(in) {
 			e.marshal("", k)
 			e.marshal("", in.MapIndex(k))
 		}
 	})
 }
 
+// sortedKeys returns the keys of the map m in the key order of e.
+// Keys marshalled as their text are ordered by it.
+func (e *encoder) sortedKeys(m reflect.Value) []reflect.Value {
+	keys := m.MapKeys()
+	by := make([]reflect.Value, len(keys))
+	for i, k := range keys {
+		if text, ok := e.keyText(k); ok {
+			by[i] = reflect.ValueOf(text)
+		} else {
+			by[i] = k
+		}
+	}
+	sort.Sort(keySorter{keys, by, e.keyOrder})
+	return keys
+}
+
+// keyText returns the text that the map key k is marshalled as, if it's
+// an encoding.TextMarshaler that no codec or Marshaler takes over.
+func (e *encoder) keyText(k reflect.Value) (text string, ok bool) {
+	if k.Kind() == reflect.Interface {
+		k = k.Elem()
+	}
+	if !k.IsValid() || k.Kind() == reflect.Ptr && k.IsNil() || e.codecs.marshalFunc(k.Type()) != nil {
+		return "", false
+	}
+	iface := k.Interface()
+	if _, ok := iface.(Marshaler); ok {
+		return "", false
+	}
+	m, ok := iface.(encoding.TextMarshaler)
+	if !ok {
+		return "", false
+	}
+	data, err := m.MarshalText()
+	if err != nil {
+		fail(err)
+	}
+	return string(data), true
+}
+
 func (e *encoder) itemsv(tag string, in reflect.Value) {
+	slice := in.Convert(reflect.TypeOf([]MapItem{})).Interface().([]MapItem)
+	if item := e.discriminator; item != nil {
+		for _, it := range slice {
+			if holdsString(reflect.ValueOf(it.Key), item.Key.(string)) {
+				e.ownDiscriminator(reflect.ValueOf(it.Value))
+				break
+			}
+		}
+	}
 	e.mappingv(tag, func() {
-		slice := in.Convert(reflect.TypeOf([]MapItem{})).Interface().([]MapItem)
 		for _, item := range slice {
 			e.marshal("", reflect.ValueOf(item.Key))
 			e.marshal("", reflect.ValueOf(item.Value))
@@ -146,34 +297,50 @@ func (e *encoder) itemsv(tag string, in reflect.Value) {
 }
 
 func (e *encoder) structv(tag string, in reflect.Value) {
-	sinfo, err := getStructInfo(in.Type())
+	sinfo, err := getStructInfo(in.Type(), e.structOptions)
 	if err != nil {
 		panic(err)
 	}
+	// A field with the key of the discriminator writes it in its own
+	// place if it holds the registered name. Otherwise the field is
+	// left out, and the discriminator is written as usual.
+	var skip string
+	if item := e.discriminator; item != nil {
+		if info, ok := sinfo.FieldsMap[item.Key.(string)]; ok {
+			value := info.value(in, false)
+			if value.IsValid() && !e.omitField(&info, value) && holdsString(value, item.Value.(string)) {
+				e.discriminator = nil
+			} else {
+				skip = info.Key
+			}
+		}
+	}
 	e.mappingv(tag, func() {
 		for _, info := range sinfo.FieldsList {
-			var value reflect.Value
-			if info.Inline == nil {
-				value = in.Field(info.Num)
-			} else {
-				value = in.FieldByIndex(info.Inline)
+			if info.Key == skip {
+				continue
+			}
+			value := info.value(in, false)
+			if !value.IsValid() {
+				continue // In a nil embedded struct
 			}
-			if info.OmitEmpty && isZero(value) {
+			if e.omitField(&info, value) {
 				continue
 			}
 			e.marshal("", reflect.ValueOf(info.Key))
 			e.flow = info.Flow
+			e.field = &info
 			e.marshal("", value)
 		}
 		if sinfo.InlineMap >= 0 {
 			m := in.Field(sinfo.InlineMap)
 			if m.Len() > 0 {
 				e.flow = false
-				keys := keyList(m.MapKeys())
-				sort.Sort(keys)
-				for _, k := range keys {
-					if _, found := sinfo.FieldsMap[k.String()]; found {
-						panic(fmt.Sprintf("Can't have key %q in inlined map; conflicts with struct field", k.String()))
+				for _, k := range e.sortedKeys(m) {
+					if name, ok := inlineKey(k); ok {
+						if _, found := sinfo.FieldsMap[name]; found {
+							panic(fmt.Sprintf("Can't have key %q in inlined map; conflicts with struct field", name))
+						}
 					}
 					e.marshal("", k)
 					e.flow = false
@@ -184,6 +351,53 @@ func (e *encoder) structv(tag string, in reflect.Value) {
 	})
 }
 
+// ownDiscriminator lets a map holding value under the key of the
+// discriminator write it in its own place, failing if value isn't the
+// registered name.
+func (e *encoder) ownDiscriminator(value reflect.Value) {
+	item := e.discriminator
+	if !holdsString(value, item.Value.(string)) {
+		failf("cannot marshal %s key holding %v where %q is expected", item.Key, value.Interface(), item.Value)
+	}
+	e.discriminator = nil
+}
+
+// omitField returns whether the struct field info, holding value, is
+// left out of the output.
+func (e *encoder) omitField(info *fieldInfo, value reflect.Value) bool {
+	return (info.OmitEmpty || e.omitEmpty) && isZero(value) || info.OmitZero && isZeroValue(value)
+}
+
+// holdsString returns whether v is the string s, or points to it.
+func holdsString(v reflect.Value, s string) bool {
+	for (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && !v.IsNil() {
+		v = v.Elem()
+	}
+	return v.Kind() == reflect.String && v.String() == s
+}
+
+// inlineKey returns the string that k, a key of an ,inline map, is
+// marshalled as, if any.
+func inlineKey(k reflect.Value) (name string, ok bool) {
+	if k.Kind() == reflect.Interface {
+		if k.IsNil() {
+			return "", false
+		}
+		k = k.Elem()
+	}
+	if m, ok := k.Interface().(encoding.TextMarshaler); ok {
+		text, err := m.MarshalText()
+		if err != nil {
+			fail(err)
+		}
+		return string(text), true
+	}
+	if k.Kind() == reflect.String {
+		return k.String(), true
+	}
+	return "", false
+}
+
 func (e *encoder) mappingv(tag string, f func()) {
 	implicit := tag == ""
 	style := yaml_BLOCK_MAPPING_STYLE
@@ -193,6 +407,11 @@ func (e *encoder) mappingv(tag string, f func()) {
 	}
 	e.must(yaml_mapping_start_event_initialize(&e.event, nil, []byte(tag), implicit, style))
 	e.emit()
+	if item := e.discriminator; item != nil {
+		e.discriminator = nil
+		e.marshal("", reflect.ValueOf(item.Key))
+		e.marshal("", reflect.ValueOf(item.Value))
+	}
 	f()
 	e.must(yaml_mapping_end_event_initialize(&e.event))
 	e.emit()
@@ -237,10 +456,16 @@ func isBase60Float(s string) (result bool) {
 // is bogus. In practice parsers do not enforce the "\.[0-9_]*" suffix.
 var base60float = regexp.MustCompile(`^[-+]?[0-9][0-9_]*(?::[0-5]?[0-9])+(?:\.[0-9_]*)?$`)
 
-func (e *encoder) stringv(tag string, in reflect.Value) {
+// stringv emits the string in, which is the marshaled form of a value
+// of type from. Strings recognized by a custom resolver are only left
+// unquoted when that resolver reads them back as a value of type from.
+func (e *encoder) stringv(tag string, in reflect.Value, from reflect.Type) {
 	var style yaml_scalar_style_t
 	s := in.String()
-	rtag, rs := resolve("", s)
+	rtag, rs, custom := e.resolvers.resolve("", s)
+	if custom && reflect.TypeOf(rs) == from {
+		rtag = yaml_STR_TAG
+	}
 	if rtag == yaml_BINARY_TAG {
 		if tag == "" || tag == yaml_STR_TAG {
 			tag = rtag
@@ -251,8 +476,10 @@ func (e *encoder) stringv(tag string, in reflect.Value) {
 			failf("cannot marshal invalid UTF-8 data as %s", shortTag(tag))
 		}
 	}
-	if tag == "" && (rtag != yaml_STR_TAG || isBase60Float(s)) {
-		style = yaml_DOUBLE_QUOTED_SCALAR_STYLE
+	if e.field != nil && e.field.Style != 0 {
+		style = e.field.Style
+	} else if tag == "" && e.needsQuotes(s, rtag) {
+		style = e.quoteStyle()
 	} else if strings.Contains(s, "\n") {
 		style = yaml_LITERAL_SCALAR_STYLE
 	} else {
@@ -272,18 +499,90 @@ func (e *encoder) boolv(tag string, in reflect.Value) {
 }
 
 func (e *encoder) intv(tag string, in reflect.Value) {
-	s := strconv.FormatInt(in.Int(), 10)
+	i := in.Int()
+	var s string
+	if i < 0 {
+		s = "-" + formatUint(uint64(-i), e.base())
+	} else {
+		s = formatUint(uint64(i), e.base())
+	}
 	e.emitScalar(s, "", tag, yaml_PLAIN_SCALAR_STYLE)
 }
 
 func (e *encoder) uintv(tag string, in reflect.Value) {
-	s := strconv.FormatUint(in.Uint(), 10)
+	s := formatUint(in.Uint(), e.base())
 	e.emitScalar(s, "", tag, yaml_PLAIN_SCALAR_STYLE)
 }
 
+// base returns the base that the next integer is written in.
+func (e *encoder) base() int {
+	if e.field != nil && e.field.Base != 0 {
+		return e.field.Base
+	}
+	return 10
+}
+
+var integerBases = map[string]int{"hex": 16, "octal": 8, "binary": 2}
+
+// setBase sets the base of info from flag, one of the hex, octal and
+// binary options, checking that t is an integer type.
+func (info *fieldInfo) setBase(flag string, t reflect.Type) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+	default:
+		return errors.New("option " + flag + " needs an integer field")
+	}
+	if info.Base != 0 {
+		return errors.New("multiple integer bases")
+	}
+	info.Base = integerBases[flag]
+	return nil
+}
+
+// setPrecision sets the precision of info from the argument of the
+// prec= option, checking that t is a floating-point type.
+func (info *fieldInfo) setPrecision(arg string, t reflect.Type) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Float32 && t.Kind() != reflect.Float64 {
+		return errors.New("option prec= needs a float field")
+	}
+	prec, err := strconv.Atoi(arg)
+	if err != nil || prec < 0 {
+		return fmt.Errorf("invalid precision %q", arg)
+	}
+	info.Precision = prec
+	return nil
+}
+
+// formatUint formats u in base, with the prefix of the base.
+func formatUint(u uint64, base int) string {
+	s := strconv.FormatUint(u, base)
+	switch base {
+	case 16:
+		return "0x" + s
+	case 8:
+		if u != 0 {
+			return "0" + s
+		}
+	case 2:
+		return "0b" + s
+	}
+	return s
+}
+
 func (e *encoder) floatv(tag string, in reflect.Value) {
-	// FIXME: Handle 64 bits here.
-	s := strconv.FormatFloat(float64(in.Float()), 'g', -1, 32)
+	var s string
+	if e.field != nil && e.field.Precision >= 0 {
+		s = strconv.FormatFloat(in.Float(), 'f', e.field.Precision, in.Type().Bits())
+	} else {
+		s = strconv.FormatFloat(in.Float(), 'g', -1, in.Type().Bits())
+	}
 	switch s {
 	case "+Inf":
 		s = ".inf"
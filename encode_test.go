@@ -1,8 +1,12 @@
 package yaml_test
 
 import (
+	"bytes"
 	"fmt"
 	"math"
+	"math/big"
+	"net/url"
+	"reflect"
 	"strconv"
 	"strings"
 	"time"
@@ -73,6 +77,15 @@ var marshalTests = []struct {
 	}, {
 		map[string]interface{}{"v": -0.1},
 		"v: -0.1\n",
+	}, {
+		map[string]interface{}{"v": 1.0 / 3},
+		"v: 0.3333333333333333\n",
+	}, {
+		map[string]interface{}{"v": float32(0.1)},
+		"v: 0.1\n",
+	}, {
+		map[string]float64{"v": 123456789.123},
+		"v: 1.23456789123e+08\n",
 	}, {
 		map[string]interface{}{"v": math.Inf(+1)},
 		"v: .inf\n",
@@ -196,6 +209,56 @@ var marshalTests = []struct {
 			B float64 "b,omitempty"
 		}{1, 0},
 		"a: 1\n",
+	}, {
+		&struct {
+			A time.Time "a,omitempty"
+		}{},
+		"{}\n",
+	}, {
+		&struct {
+			A optionalInt "a,omitempty"
+			B optionalInt "b,omitempty"
+		}{optionalInt{0, true}, optionalInt{1, false}},
+		"a:\n  v: 0\n",
+	},
+
+	// Zero omission flag
+	{
+		&struct {
+			A struct{ X, y int } "a,omitzero,flow"
+		}{struct{ X, y int }{0, 1}},
+		"a: {x: 0}\n",
+	}, {
+		&struct {
+			A struct{ X, y int } "a,omitzero,flow"
+		}{},
+		"{}\n",
+	}, {
+		&struct {
+			A []int "a,omitzero"
+			B []int "b,omitzero"
+		}{[]int{}, nil},
+		"a: []\n",
+	}, {
+		&struct {
+			A optionalInt "a,omitzero"
+			B time.Time   "b,omitzero"
+		}{optionalInt{1, false}, time.Time{}},
+		"{}\n",
+	},
+
+	// Number format flags
+	{
+		&struct {
+			A int     "a,hex"
+			B uint32  "b,octal"
+			C *int8   "c,binary"
+			D int64   "d,hex"
+			E uint    "e,octal"
+			F float64 "f,prec=2"
+			G float32 "g,prec=0"
+		}{420, 0644, new(int8), -26, 0, 3.14159, 2.5},
+		"a: 0x1a4\nb: 0644\nc: 0b0\nd: -0x1a\ne: 0\nf: 3.14\ng: 2\n",
 	},
 
 	// Flow flag
@@ -218,6 +281,34 @@ var marshalTests = []struct {
 		"a: {b: c, d: e}\n",
 	},
 
+	// Scalar style flags
+	{
+		&struct {
+			A string "a,literal"
+			B string "b,literal"
+		}{"x", "x\ny\n"},
+		"a: |-\n  x\nb: |\n  x\n  y\n",
+	}, {
+		&struct {
+			A string "a,folded"
+		}{"long line\nnext"},
+		"a: >-\n  long line\n\n  next\n",
+	}, {
+		&struct {
+			A string  "a,singlequoted"
+			B *string "b,singlequoted"
+			C string  "c,doublequoted"
+			D int     "d,doublequoted"
+		}{"true", new(string), "plain", 1},
+		"a: 'true'\nb: ''\nc: \"plain\"\nd: 1\n",
+	}, {
+		&struct {
+			A string   "a,literal"
+			B []string "b,literal,flow"
+		}{"trailing \n", []string{"x\ny"}},
+		"a: \"trailing \\n\"\nb: [\"x\\ny\"]\n",
+	},
+
 	// Unexported field
 	{
 		&struct {
@@ -253,6 +344,36 @@ var marshalTests = []struct {
 		}{1, map[string]int{"b": 2, "c": 3}},
 		"a: 1\nb: 2\nc: 3\n",
 	},
+	{
+		&struct {
+			A int
+			C map[interface{}]interface{} `yaml:",inline"`
+		}{1, map[interface{}]interface{}{2: "b", "c": 3}},
+		"a: 1\n2: b\nc: 3\n",
+	},
+	{
+		&struct {
+			A int
+			C map[textKey]int `yaml:",inline"`
+		}{1, map[textKey]int{{"b", "c"}: 2}},
+		"a: 1\nb/c: 2\n",
+	},
+
+	// Pointer to struct inlining
+	{
+		&struct {
+			A int
+			C *inlineB `yaml:",inline"`
+		}{1, &inlineB{2, inlineC{3}}},
+		"a: 1\nb: 2\nc: 3\n",
+	},
+	{
+		&struct {
+			A int
+			C *inlineB `yaml:",inline"`
+		}{A: 1},
+		"a: 1\n",
+	},
 
 	// Duration
 	{
@@ -322,6 +443,28 @@ var marshalTests = []struct {
 		map[string]string{"a": "你好 #comment"},
 		"a: '你好 #comment'\n",
 	},
+
+	// Built-in codecs.
+	{
+		map[string]*url.URL{"a": {Scheme: "http", Host: "example.com", Path: "/path", RawQuery: "q=1"}},
+		"a: http://example.com/path?q=1\n",
+	},
+	{
+		map[string]net.IPNet{"a": {IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(8, 32)}},
+		"a: 10.0.0.0/8\n",
+	},
+	{
+		map[string]*big.Int{"a": big.NewInt(-42)},
+		"a: -42\n",
+	},
+	{
+		map[string]*big.Int{"a": bigInt("123456789012345678901234567890")},
+		"a: 123456789012345678901234567890\n",
+	},
+	{
+		map[string]*big.Float{"a": big.NewFloat(1.5)},
+		"a: 1.5\n",
+	},
 }
 
 func (s *S) TestMarshal(c *C) {
@@ -350,6 +493,47 @@ var marshalErrorTests = []struct {
 		B map[string]int ",inline"
 	}{1, map[string]int{"a": 2}},
 	panic: `Can't have key "a" in inlined map; conflicts with struct field`,
+}, {
+	value: &struct {
+		A int
+		B map[interface{}]int ",inline"
+	}{1, map[interface{}]int{"a": 2}},
+	panic: `Can't have key "a" in inlined map; conflicts with struct field`,
+}, {
+	value: &struct {
+		A *int ",inline"
+	}{},
+	panic: `Option ,inline needs a struct, pointer to struct or map field in struct .*`,
+}, {
+	value: &struct {
+		A string "a,hex"
+	}{},
+	panic: `Invalid flag "hex" in tag "a,hex" of type .*: option hex needs an integer field`,
+}, {
+	value: &struct {
+		A int "a,hex,octal"
+	}{},
+	panic: `Invalid flag "octal" in tag "a,hex,octal" of type .*: multiple integer bases`,
+}, {
+	value: &struct {
+		A int "a,prec=2"
+	}{},
+	panic: `Invalid flag "prec=2" in tag "a,prec=2" of type .*: option prec= needs a float field`,
+}, {
+	value: &struct {
+		A float64 "a,prec=x"
+	}{},
+	panic: `Invalid flag "prec=x" in tag "a,prec=x" of type .*: invalid precision "x"`,
+}, {
+	value: &struct {
+		A string "a,literal,folded"
+	}{},
+	panic: `Multiple scalar styles in tag "a,literal,folded" of type .*`,
+}, {
+	value: &struct {
+		A map[int]int ",inline"
+	}{},
+	panic: `Option ,inline needs a map with string, interface{} or encoding.TextUnmarshaler keys in struct .*`,
 }}
 
 func (s *S) TestMarshalErrors(c *C) {
@@ -390,6 +574,16 @@ var marshalerTests = []struct {
 	{"_: BAR!\n", "BAR!"},
 }
 
+// optionalInt is zero when not valid, whatever its value.
+type optionalInt struct {
+	V     int
+	valid bool
+}
+
+func (o optionalInt) IsZero() bool {
+	return !o.valid
+}
+
 type marshalerType struct {
 	value interface{}
 }
@@ -435,6 +629,522 @@ func (s *S) TestMarshalerError(c *C) {
 	c.Assert(err, Equals, failingErr)
 }
 
+func (s *S) TestEncoderCodec(c *C) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.RegisterCodec(reflect.TypeOf(codecPoint{}), yaml.Codec{
+		Marshal: func(in interface{}) (interface{}, error) {
+			p := in.(codecPoint)
+			return []int{p.X, p.Y}, nil
+		},
+	})
+	err := enc.Encode(map[string]*codecPoint{"a": {1, 2}})
+	c.Assert(err, IsNil)
+	c.Assert(enc.Close(), IsNil)
+	c.Assert(buf.String(), Equals, "a:\n- 1\n- 2\n")
+
+	// Codecs registered on an encoder don't leak into Marshal.
+	data, err := yaml.Marshal(codecPoint{1, 2})
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "x: 1\n\"y\": 2\n")
+}
+
+func (s *S) TestCodecNetIP(c *C) {
+	// net.IP is handled as text by default, and a codec may write it
+	// as a sequence of bytes instead.
+	ipCodec := yaml.Codec{
+		Marshal: func(in interface{}) (interface{}, error) {
+			return []byte(in.(net.IP).To4()), nil
+		},
+		Unmarshal: func(out interface{}, unmarshal func(interface{}) error) error {
+			var b []byte
+			if err := unmarshal(&b); err != nil {
+				return err
+			}
+			if len(b) != net.IPv4len {
+				return fmt.Errorf("invalid IPv4 address %v", b)
+			}
+			*out.(*net.IP) = net.IPv4(b[0], b[1], b[2], b[3])
+			return nil
+		},
+	}
+	ipType := reflect.TypeOf(net.IP{})
+	v := map[string]net.IP{"a": net.IPv4(10, 0, 0, 1)}
+
+	data, err := yaml.Marshal(v)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "a: 10.0.0.1\n")
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.RegisterCodec(ipType, ipCodec)
+	c.Assert(enc.Encode(v), IsNil)
+	c.Assert(enc.Close(), IsNil)
+	c.Assert(buf.String(), Equals, "a:\n- 10\n- 0\n- 0\n- 1\n")
+
+	var w map[string]net.IP
+	dec := yaml.NewDecoder(&buf)
+	dec.RegisterCodec(ipType, ipCodec)
+	c.Assert(dec.Decode(&w), IsNil)
+	c.Assert(w, DeepEquals, v)
+
+	dec = yaml.NewDecoder(strings.NewReader("a: [1, 2]\n"))
+	dec.RegisterCodec(ipType, ipCodec)
+	c.Assert(dec.Decode(&w), ErrorMatches, `invalid IPv4 address \[1 2\]`)
+}
+
+func (s *S) TestEncoderResolver(c *C) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.RegisterResolver(quantityResolver)
+	err := enc.Encode(map[string]interface{}{
+		"a": quantity("10Gi"),
+		"b": "10Gi",
+		"c": "10G",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(enc.Close(), IsNil)
+	c.Assert(buf.String(), Equals, "a: 10Gi\nb: \"10Gi\"\nc: 10G\n")
+}
+
+func (s *S) TestEncoderMultipleDocuments(c *C) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	c.Assert(enc.Encode(map[string]int{"a": 1}), IsNil)
+	c.Assert(enc.Encode([]string{"b"}), IsNil)
+	c.Assert(enc.Close(), IsNil)
+	c.Assert(buf.String(), Equals, "a: 1\n---\n- b\n")
+}
+
+func (s *S) TestMarshalRawMessage(c *C) {
+	v := struct {
+		Name   string
+		Config yaml.RawMessage
+		List   []yaml.RawMessage
+		Flow   yaml.RawMessage
+		Text   yaml.RawMessage
+		Empty  yaml.RawMessage
+	}{
+		Name:   "plugin",
+		Config: yaml.RawMessage("a: 1   # one\nb:\n- x\n"),
+		List:   []yaml.RawMessage{yaml.RawMessage("x: 1\ny: 2"), yaml.RawMessage("- a\n- b")},
+		Flow:   yaml.RawMessage("{a: 1,\n      b: 2}"),
+		Text:   yaml.RawMessage("|\n    line 1\n    line 2\n"),
+	}
+	data, err := yaml.Marshal(&v)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, ""+
+		"name: plugin\n"+
+		"config:\n"+
+		"  a: 1   # one\n"+
+		"  b:\n"+
+		"  - x\n"+
+		"list:\n"+
+		"- x: 1\n"+
+		"  y: 2\n"+
+		"- - a\n"+
+		"  - b\n"+
+		"flow: {a: 1,\n"+
+		"  b: 2}\n"+
+		"text: |\n"+
+		"  line 1\n"+
+		"  line 2\n"+
+		"empty: null\n")
+
+	data, err = yaml.Marshal(yaml.RawMessage("a: 1\nb: 2\n"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "a: 1\nb: 2\n")
+}
+
+func (s *S) TestMarshalRawMessageErrors(c *C) {
+	_, err := yaml.Marshal(yaml.RawMessage("a: [1"))
+	c.Assert(err, ErrorMatches, "yaml: line 1: did not find expected ',' or ']'")
+	_, err = yaml.Marshal(yaml.RawMessage("a\n---\nb"))
+	c.Assert(err, ErrorMatches, "yaml: invalid RawMessage: more than one document found")
+	for _, text := range []string{"--- 1", "1\n...\n", "%YAML 1.1\n--- 1", "%TAG !e! tag:e.com:\n--- !e!a 1"} {
+		_, err = yaml.Marshal(map[string]yaml.RawMessage{"a": yaml.RawMessage(text)})
+		c.Assert(err, ErrorMatches, "yaml: invalid RawMessage: directives and document markers are not allowed", Commentf("raw %q", text))
+	}
+	_, err = yaml.Marshal(yaml.RawMessage("- *q"))
+	c.Assert(err, ErrorMatches, "yaml: invalid RawMessage: unknown anchor 'q' referenced")
+	data, err := yaml.Marshal(yaml.RawMessage("- &q 1\n- *q"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "- &q 1\n- *q\n")
+	v := struct {
+		A yaml.RawMessage `yaml:",flow"`
+	}{yaml.RawMessage("a: 1")}
+	_, err = yaml.Marshal(map[string]interface{}{"v": []interface{}{v}, "w": v})
+	c.Assert(err, IsNil)
+	_, err = yaml.Marshal(struct {
+		L []yaml.RawMessage `yaml:",flow"`
+	}{[]yaml.RawMessage{yaml.RawMessage("a: 1")}})
+	c.Assert(err, ErrorMatches, "yaml: cannot emit a raw block collection in flow context")
+}
+
+func (s *S) TestMarshalUnion(c *C) {
+	v := struct {
+		Shapes []shape
+	}{[]shape{circle{1}, &square{Side: 2}, &square{Kind: "square", Side: 3}, &square{Kind: "circle", Side: 4}, (*square)(nil), nil}}
+	data, err := yaml.Marshal(&v)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "shapes:\n"+
+		"- kind: circle\n  radius: 1\n"+
+		"- kind: square\n  side: 2\n"+
+		"- kind: square\n  side: 3\n"+
+		"- kind: square\n  side: 4\n"+
+		"- null\n- null\n")
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.RegisterUnion(shapeType, yaml.Union{
+		Key:   "type",
+		Types: map[string]reflect.Type{"round": reflect.TypeOf(circle{})},
+	})
+	c.Assert(enc.Encode(map[string]shape{"a": circle{2}}), IsNil)
+	c.Assert(enc.Close(), IsNil)
+	c.Assert(buf.String(), Equals, "a:\n  type: round\n  radius: 2\n")
+
+	// Fields holding the discriminator are written in place if they
+	// are set to the registered name.
+	buf.Reset()
+	enc = yaml.NewEncoder(&buf)
+	enc.RegisterUnion(shapeType, yaml.Union{
+		Key:   "kind",
+		Types: map[string]reflect.Type{"triangle": reflect.TypeOf(triangle{})},
+	})
+	c.Assert(enc.Encode([]shape{triangle{Base: 1}, triangle{Base: 2, Kind: "triangle"}}), IsNil)
+	c.Assert(enc.Close(), IsNil)
+	c.Assert(buf.String(), Equals, "- kind: triangle\n  base: 1\n- base: 2\n  kind: triangle\n")
+
+	_, err = yaml.Marshal([]shape{unknownShape{}})
+	c.Assert(err, ErrorMatches, "yaml: cannot marshal yaml_test.unknownShape as yaml_test.shape: type is not in its union")
+}
+
+func (s *S) TestMarshalUnionMap(c *C) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.RegisterUnion(shapeType, yaml.Union{
+		Key: "kind",
+		Types: map[string]reflect.Type{
+			"polygon": reflect.TypeOf(polygon{}),
+			"path":    reflect.TypeOf(path{}),
+		},
+	})
+	c.Assert(enc.Encode([]shape{
+		polygon{"sides": 3},
+		polygon{"sides": 4, "kind": "polygon"},
+		path{{"points", 2}},
+		path{{"points", 3}, {"kind", "path"}},
+	}), IsNil)
+	c.Assert(enc.Close(), IsNil)
+	c.Assert(buf.String(), Equals, ""+
+		"- kind: polygon\n  sides: 3\n"+
+		"- kind: polygon\n  sides: 4\n"+
+		"- kind: path\n  points: 2\n"+
+		"- points: 3\n  kind: path\n")
+
+	for _, v := range []shape{polygon{"kind": "path"}, path{{"kind", 1}}} {
+		enc = yaml.NewEncoder(&buf)
+		enc.RegisterUnion(shapeType, yaml.Union{
+			Key: "kind",
+			Types: map[string]reflect.Type{
+				"polygon": reflect.TypeOf(polygon{}),
+				"path":    reflect.TypeOf(path{}),
+			},
+		})
+		c.Assert(enc.Encode([]shape{v}), ErrorMatches, `yaml: cannot marshal kind key holding .* where "(polygon|path)" is expected`)
+	}
+}
+
+type polygon map[string]interface{}
+
+func (polygon) area() float64 { return 0 }
+
+type path yaml.MapSlice
+
+func (path) area() float64 { return 0 }
+
+type unknownShape struct{}
+
+type triangle struct {
+	Base float64
+	Kind string `yaml:",omitempty"`
+}
+
+func (t triangle) area() float64 { return t.Base }
+
+func (unknownShape) area() float64 { return 0 }
+
+func (s *S) TestEncoderJSONFallback(c *C) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetJSONFallback(true)
+	v := jsonSettings{MaxRetries: 3, Secret: "s", Dash: "d", Name: "t", Level: 2}
+	c.Assert(enc.Encode(v), IsNil)
+	c.Assert(enc.Close(), IsNil)
+	c.Assert(buf.String(), Equals, ""+
+		"maxRetries: 3\n"+
+		"'-': d\n"+
+		"title: t\n"+
+		"level: '**'\n"+
+		"labels:\n"+
+		"  z: last\n"+
+		"  a:\n"+
+		"  - 1\n"+
+		"  - /x\n")
+}
+
+func (s *S) TestEncoderNamingStrategy(c *C) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetNamingStrategy(yaml.KebabCase)
+	v := struct {
+		MaxRetries int
+		HTTPServer string `yaml:",omitempty"`
+		Tagged     int    `yaml:"TAGGED"`
+	}{MaxRetries: 3}
+	c.Assert(enc.Encode(v), IsNil)
+	c.Assert(enc.Close(), IsNil)
+	c.Assert(buf.String(), Equals, "max-retries: 3\nTAGGED: 0\n")
+}
+
+func (s *S) TestEncoderAutoInline(c *C) {
+	type Base struct {
+		ID   int
+		Name string
+	}
+	type Meta struct {
+		Name string `yaml:"name"`
+		Note string
+	}
+	type T struct {
+		Base
+		*Meta
+		Note string
+	}
+	for _, t := range []struct {
+		value T
+		data  string
+	}{
+		// The tagged name of Meta hides the one of Base, even if nil.
+		{T{Base{1, "b"}, nil, "x"}, "id: 1\nnote: x\n"},
+		{T{Base{1, "b"}, &Meta{"m", "y"}, "x"}, "id: 1\nname: m\nnote: x\n"},
+	} {
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetAutoInline(true)
+		c.Assert(enc.Encode(t.value), IsNil)
+		c.Assert(enc.Close(), IsNil)
+		c.Assert(buf.String(), Equals, t.data)
+	}
+}
+
+func (s *S) TestEncoderKeyOrder(c *C) {
+	v := map[string]interface{}{
+		"metadata":   map[interface{}]interface{}{"name": "x", 10: "ten", 9: "nine"},
+		"kind":       "Pod",
+		"apiVersion": "v1",
+		"a10":        1,
+		"a9":         2,
+		"B":          3,
+		"spec": struct {
+			Z int
+			M map[string]int `yaml:",inline"`
+		}{1, map[string]int{"kind": 4, "b": 5}},
+		"items": yaml.MapSlice{{"z", 1}, {"apiVersion", 2}},
+	}
+	tests := []struct {
+		order yaml.KeyOrder
+		want  string
+	}{{
+		nil,
+		"B: 3\na9: 2\na10: 1\napiVersion: v1\nitems:\n  z: 1\n  apiVersion: 2\nkind: Pod\n" +
+			"metadata:\n  9: nine\n  10: ten\n  name: x\nspec:\n  z: 1\n  b: 5\n  kind: 4\n",
+	}, {
+		yaml.LexicalOrder,
+		"B: 3\na10: 1\na9: 2\napiVersion: v1\nitems:\n  z: 1\n  apiVersion: 2\nkind: Pod\n" +
+			"metadata:\n  10: ten\n  9: nine\n  name: x\nspec:\n  z: 1\n  b: 5\n  kind: 4\n",
+	}, {
+		yaml.KeysFirst("apiVersion", "kind", "metadata"),
+		"apiVersion: v1\nkind: Pod\nmetadata:\n  9: nine\n  10: ten\n  name: x\nB: 3\na9: 2\na10: 1\n" +
+			"items:\n  z: 1\n  apiVersion: 2\nspec:\n  z: 1\n  kind: 4\n  b: 5\n",
+	}, {
+		func(a, b interface{}) bool { return yaml.NaturalOrder(b, a) },
+		"spec:\n  z: 1\n  kind: 4\n  b: 5\nmetadata:\n  name: x\n  10: ten\n  9: nine\nkind: Pod\n" +
+			"items:\n  z: 1\n  apiVersion: 2\napiVersion: v1\na10: 1\na9: 2\nB: 3\n",
+	}}
+	for i, test := range tests {
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetKeyOrder(test.order)
+		c.Assert(enc.Encode(v), IsNil)
+		c.Assert(enc.Close(), IsNil)
+		c.Assert(buf.String(), Equals, test.want, Commentf("test %d", i))
+	}
+}
+
+func (s *S) TestMarshalTextKeys(c *C) {
+	data, err := yaml.Marshal(map[hexKey]int{2: 2, 10: 10, 16: 16})
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "\"2\": 2\n\"10\": 16\na: 10\n")
+
+	data, err = yaml.Marshal(map[interface{}]int{hexKey(10): 10, 9: 9, "b": 11})
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "9: 9\na: 10\nb: 11\n")
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetKeyOrder(yaml.KeysFirst("a"))
+	c.Assert(enc.Encode(map[hexKey]int{2: 2, 10: 10}), IsNil)
+	c.Assert(enc.Close(), IsNil)
+	c.Assert(buf.String(), Equals, "a: 10\n\"2\": 2\n")
+}
+
+func (s *S) TestEncoderOmitEmpty(c *C) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetOmitEmpty(true)
+	v := struct {
+		A int
+		B string
+		C []int
+		D optionalInt
+		E int `yaml:"e,omitzero"`
+		F int
+	}{C: []int{}, F: 1}
+	c.Assert(enc.Encode(v), IsNil)
+	c.Assert(enc.Close(), IsNil)
+	c.Assert(buf.String(), Equals, "f: 1\n")
+}
+
+var quotingTests = []struct {
+	policy yaml.QuotingPolicy
+	quotes yaml.QuoteStyle
+	data   string
+}{{
+	yaml.QuoteMinimal, yaml.DoubleQuotes,
+	"- plain\n- \"true\"\n- \"1:20\"\n- 2001-01-01\n- 2001-12-14t21:59:43.10-05:00\n- \"0o17\"\n- =\n- <<\n- it's\n- |-\n  a\n  b\n",
+}, {
+	yaml.QuoteCompatible, yaml.DoubleQuotes,
+	"- plain\n- \"true\"\n- \"1:20\"\n- \"2001-01-01\"\n- \"2001-12-14t21:59:43.10-05:00\"\n- \"0o17\"\n- \"=\"\n- \"<<\"\n- it's\n- |-\n  a\n  b\n",
+}, {
+	yaml.QuoteCompatible, yaml.SingleQuotes,
+	"- plain\n- 'true'\n- '1:20'\n- '2001-01-01'\n- '2001-12-14t21:59:43.10-05:00'\n- '0o17'\n- '='\n- '<<'\n- it's\n- |-\n  a\n  b\n",
+}, {
+	yaml.QuoteAlways, yaml.DoubleQuotes,
+	"- \"plain\"\n- \"true\"\n- \"1:20\"\n- \"2001-01-01\"\n- \"2001-12-14t21:59:43.10-05:00\"\n- \"0o17\"\n- \"=\"\n- \"<<\"\n- \"it's\"\n- \"a\\nb\"\n",
+}, {
+	yaml.QuoteAlways, yaml.SingleQuotes,
+	"- 'plain'\n- 'true'\n- '1:20'\n- '2001-01-01'\n- '2001-12-14t21:59:43.10-05:00'\n- '0o17'\n- '='\n- '<<'\n- 'it''s'\n- 'a\n\n  b'\n",
+}}
+
+func (s *S) TestEncoderQuotingPolicy(c *C) {
+	value := []string{"plain", "true", "1:20", "2001-01-01", "2001-12-14t21:59:43.10-05:00", "0o17", "=", "<<", "it's", "a\nb"}
+	for _, t := range quotingTests {
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetQuotingPolicy(t.policy)
+		enc.SetQuoteStyle(t.quotes)
+		c.Assert(enc.Encode(value), IsNil)
+		c.Assert(enc.Close(), IsNil)
+		c.Assert(buf.String(), Equals, t.data, Commentf("policy %d, quotes %d", t.policy, t.quotes))
+
+		var back []string
+		c.Assert(yaml.Unmarshal(buf.Bytes(), &back), IsNil)
+		c.Assert(back, DeepEquals, value)
+	}
+
+	// Keys are quoted too, and scalar style flags take precedence.
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetQuotingPolicy(yaml.QuoteAlways)
+	v := struct {
+		A string
+		B string `yaml:"b,literal"`
+	}{"x", "y"}
+	c.Assert(enc.Encode(v), IsNil)
+	c.Assert(enc.Close(), IsNil)
+	c.Assert(buf.String(), Equals, "\"a\": \"x\"\n\"b\": |-\n  y\n")
+}
+
+func (s *S) TestEncoderAutoFlow(c *C) {
+	type T struct {
+		Tags   []string
+		Ports  map[string]int
+		Nested []map[string]int
+		Multi  []string
+		Quoted []string
+	}
+	v := T{
+		Tags:   []string{"a", "b", "c"},
+		Ports:  map[string]int{"http": 80, "https": 443},
+		Nested: []map[string]int{{"a": 1}, {"b": 2}},
+		Multi:  []string{"x\ny"},
+		Quoted: []string{"a,b", "true", "it's"},
+	}
+	for _, t := range []struct {
+		width int
+		data  string
+	}{{
+		0,
+		"tags: [a, b, c]\n" +
+			"ports: {http: 80, https: 443}\n" +
+			"nested:\n- {a: 1}\n- {b: 2}\n" +
+			"multi:\n- |-\n  x\n  y\n" +
+			"quoted: ['a,b', \"true\", it's]\n",
+	}, {
+		28,
+		"tags: [a, b, c]\n" +
+			"ports:\n  http: 80\n  https: 443\n" +
+			"nested:\n- {a: 1}\n- {b: 2}\n" +
+			"multi:\n- |-\n  x\n  y\n" +
+			"quoted:\n- a,b\n- \"true\"\n- it's\n",
+	}} {
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetAutoFlow(true)
+		enc.SetLineWidth(t.width)
+		c.Assert(enc.Encode(v), IsNil)
+		c.Assert(enc.Close(), IsNil)
+		c.Assert(buf.String(), Equals, t.data)
+
+		var back T
+		c.Assert(yaml.Unmarshal(buf.Bytes(), &back), IsNil)
+		c.Assert(back, DeepEquals, v)
+	}
+}
+
+func (s *S) TestMarshalNumber(c *C) {
+	data, err := yaml.Marshal(map[string]yaml.Number{
+		"a": "0x1F",
+		"b": "123456789012345678901234567890",
+		"c": "1.000000000000000000001",
+		"d": "",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "a: 0x1F\nb: 123456789012345678901234567890\nc: 1.000000000000000000001\nd: 0\n")
+
+	_, err = yaml.Marshal(yaml.Number("abc"))
+	c.Assert(err, ErrorMatches, `yaml: cannot marshal invalid number "abc"`)
+}
+
+func (s *S) TestMarshalOrderedMap(c *C) {
+	m := yaml.NewOrderedMap(yaml.MapItem{"z", 1}, yaml.MapItem{"a", []int{2}})
+	m.Set("m", yaml.NewOrderedMap(yaml.MapItem{"y", 3}, yaml.MapItem{"b", 4}))
+	data, err := yaml.Marshal(m)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "z: 1\na:\n- 2\nm:\n  \"y\": 3\n  b: 4\n")
+
+	v := struct {
+		A yaml.OrderedMap  `yaml:"a,omitempty"`
+		B *yaml.OrderedMap `yaml:"b,flow"`
+		C yaml.OrderedMap  `yaml:"c,omitempty"`
+	}{B: m, C: *yaml.NewOrderedMap(yaml.MapItem{"x", 5})}
+	data, err = yaml.Marshal(&v)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "b: {z: 1, a: [2], m: {\"y\": 3, b: 4}}\nc:\n  x: 5\n")
+}
+
 func (s *S) TestSortedOutput(c *C) {
 	order := []interface{}{
 		false,
@@ -0,0 +1,132 @@
+package yaml
+
+import (
+	"reflect"
+	"sort"
+)
+
+// A MapType selects the Go type of the mappings that a Decoder decodes
+// into interface{} values, and that ConvertMaps converts them to.
+type MapType int
+
+const (
+	// InterfaceMaps represents mappings as map[interface{}]interface{}.
+	// It's the default.
+	InterfaceMaps MapType = iota
+
+	// StringMaps represents mappings as map[string]interface{}, as
+	// encoding/json and most template packages expect. Decoders store
+	// keys that aren't strings as they are written in the document,
+	// and null keys as "". Keys that are collections are reported as
+	// type errors.
+	StringMaps
+
+	// MapSlices represents mappings as MapSlice, keeping their order.
+	MapSlices
+
+	// OrderedMaps represents mappings as *OrderedMap, keeping their
+	// order.
+	OrderedMaps
+)
+
+var stringMapType = reflect.TypeOf(map[string]interface{}{})
+
+// reflectType returns the type of the mappings represented as t.
+func (t MapType) reflectType() reflect.Type {
+	switch t {
+	case StringMaps:
+		return stringMapType
+	case MapSlices:
+		return reflect.TypeOf(MapSlice{})
+	case OrderedMaps:
+		return orderedMapType
+	}
+	return defaultMapType
+}
+
+// ConvertMaps returns a copy of the generic value v in which all the
+// mappings, at any depth, are represented as t. Generic values are
+// those decoded into interface{}: mappings of any MapType, []interface{}
+// sequences, and scalars, which are returned as they are.
+//
+// The keys of Go maps converted to MapSlice or *OrderedMap are sorted
+// as when they are marshalled. When a MapSlice holds a key more than
+// once, the last value wins in all other representations. Converting
+// to StringMaps fails if a mapping has a key that isn't a string, and
+// converting to Go maps fails if a key is a collection.
+func ConvertMaps(v interface{}, t MapType) (out interface{}, err error) {
+	defer handleErr(&err)
+	return convertMaps(v, t), nil
+}
+
+func convertMaps(v interface{}, t MapType) interface{} {
+	switch v := v.(type) {
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, e := range v {
+			s[i] = convertMaps(e, t)
+		}
+		return s
+	case map[interface{}]interface{}, map[string]interface{}:
+		mv := reflect.ValueOf(v)
+		keys := keyList(mv.MapKeys())
+		sort.Sort(keys)
+		items := make([]MapItem, len(keys))
+		for i, k := range keys {
+			items[i] = MapItem{k.Interface(), mv.MapIndex(k).Interface()}
+		}
+		return convertItems(items, t)
+	case MapSlice:
+		return convertItems(v, t)
+	case *OrderedMap:
+		if v == nil {
+			return v
+		}
+		return convertItems(v.items, t)
+	}
+	return v
+}
+
+// convertItems returns the mapping holding items as t, converting
+// their values with convertMaps.
+func convertItems(items []MapItem, t MapType) interface{} {
+	switch t {
+	case StringMaps:
+		m := make(map[string]interface{}, len(items))
+		for _, item := range items {
+			k, ok := item.Key.(string)
+			if !ok {
+				failf("cannot convert map key %#v to string", item.Key)
+			}
+			m[k] = convertMaps(item.Value, t)
+		}
+		return m
+	case MapSlices:
+		s := make(MapSlice, len(items))
+		for i, item := range items {
+			s[i] = MapItem{item.Key, convertMaps(item.Value, t)}
+		}
+		return s
+	case OrderedMaps:
+		m := &OrderedMap{}
+		for _, item := range items {
+			checkMapKey(item.Key)
+			m.Set(item.Key, convertMaps(item.Value, t))
+		}
+		return m
+	}
+	m := make(map[interface{}]interface{}, len(items))
+	for _, item := range items {
+		checkMapKey(item.Key)
+		m[item.Key] = convertMaps(item.Value, t)
+	}
+	return m
+}
+
+// checkMapKey fails if k can't be the key of a Go map.
+func checkMapKey(k interface{}) {
+	switch reflect.ValueOf(k).Kind() {
+	case reflect.Map, reflect.Slice, reflect.Func:
+		failf("invalid map key: %#v", k)
+	}
+}
@@ -0,0 +1,95 @@
+package yaml
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+var (
+	jsonMapType      = reflect.TypeOf(map[string]interface{}{})
+	jsonMapItemsType = reflect.TypeOf(MapSlice{})
+)
+
+// yamlTagFromJSON returns the yaml tag equivalent to the json tag
+// of a field. Only the key and the omitempty and omitzero options
+// carry over.
+func yamlTagFromJSON(tag string) string {
+	fields := strings.Split(tag, ",")
+	yamlTag := fields[0]
+	for _, flag := range fields[1:] {
+		if flag == "omitempty" || flag == "omitzero" {
+			yamlTag += "," + flag
+		}
+	}
+	return yamlTag
+}
+
+// jsonUnmarshaler returns the json.Unmarshaler implemented by out, if
+// the JSON fallback is enabled and out doesn't also implement
+// encoding.TextUnmarshaler, which takes precedence.
+func (d *decoder) jsonUnmarshaler(out reflect.Value) (u json.Unmarshaler, ok bool) {
+	if !d.jsonTags || !out.CanAddr() {
+		return nil, false
+	}
+	ptr := out.Addr().Interface()
+	if _, ok := ptr.(encoding.TextUnmarshaler); ok {
+		return nil, false
+	}
+	u, ok = ptr.(json.Unmarshaler)
+	return u, ok
+}
+
+// callJSONUnmarshaler unmarshals n into u by converting it to JSON.
+func (d *decoder) callJSONUnmarshaler(n *node, u json.Unmarshaler) (good bool) {
+	return d.callUnmarshaler(n, func(unmarshal func(interface{}) error) error {
+		mapType, opts := d.mapType, d.decodeOptions
+		d.mapType = jsonMapType
+		// Numbers and values of custom resolvers and hooks wouldn't
+		// convert to the JSON that the document stands for.
+		d.resolvers, d.hooks, d.useNumber, d.useInt64 = nil, nil, false, false
+		var v interface{}
+		err := unmarshal(&v)
+		d.mapType, d.decodeOptions = mapType, opts
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return u.UnmarshalJSON(data)
+	})
+}
+
+// jsonMarshaler returns the json.Marshaler implemented by in, if the
+// JSON fallback is enabled and in doesn't also implement
+// encoding.TextMarshaler, which takes precedence.
+func (e *encoder) jsonMarshaler(in reflect.Value) (m json.Marshaler, ok bool) {
+	if !e.jsonTags || in.Kind() == reflect.Ptr && in.IsNil() {
+		return nil, false
+	}
+	iface := in.Interface()
+	if _, ok := iface.(encoding.TextMarshaler); ok {
+		return nil, false
+	}
+	m, ok = iface.(json.Marshaler)
+	return m, ok
+}
+
+// marshalJSON returns the value to marshal in place of the JSON document
+// produced by m, keeping the order of the keys of its objects.
+func marshalJSON(m json.Marshaler) interface{} {
+	data, err := m.MarshalJSON()
+	if err != nil {
+		fail(err)
+	}
+	d := newDecoder()
+	d.mapType = jsonMapItemsType
+	var v interface{}
+	if err := unmarshal(data, &v, d); err != nil {
+		fail(err)
+	}
+	return v
+}
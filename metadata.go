@@ -0,0 +1,88 @@
+package yaml
+
+import (
+	"strings"
+)
+
+// Metadata describes how the values of a document were decoded.
+// It is returned by UnmarshalWithMetadata and DecodeWithMetadata.
+//
+// Values are identified by paths made of the keys and sequence indexes
+// leading to them from the top of the document, such as
+// "spec.ports[0].name". The keys of struct fields are the ones used in
+// the document, and keys of inlined fields appear at the level of the
+// struct they are inlined into.
+type Metadata struct {
+	// Set holds the paths of the struct fields that the document set,
+	// including to null, in the order they were first decoded. Fields
+	// that only received their default value are not included.
+	Set []string
+
+	// Unused holds the paths of the mapping keys that didn't match
+	// any field of the struct being decoded.
+	Unused []string
+
+	// Origins holds how the value of each path in Set was obtained.
+	Origins map[string]Origin
+}
+
+// Origin describes how the value of a struct field was obtained.
+type Origin struct {
+	// Alias is whether the value was decoded through an alias,
+	// either of the value itself or of an enclosing node.
+	Alias bool
+
+	// Merge is whether the value was merged in by a "<<" key.
+	Merge bool
+}
+
+// IsSet returns whether the document set the struct field at path.
+func (md *Metadata) IsSet(path string) bool {
+	_, ok := md.Origins[path]
+	return ok
+}
+
+func (d *decoder) pushPath(elem string) {
+	if d.meta != nil {
+		d.path = append(d.path, elem)
+	}
+}
+
+func (d *decoder) popPath() {
+	if d.meta != nil {
+		d.path = d.path[:len(d.path)-1]
+	}
+}
+
+// currentPath returns the path of the value being decoded.
+func (d *decoder) currentPath() string {
+	var path string
+	for i, elem := range d.path {
+		if i > 0 && !strings.HasPrefix(elem, "[") {
+			path += "."
+		}
+		path += elem
+	}
+	return path
+}
+
+// recordSet records that the document set the struct field at the
+// current path.
+func (d *decoder) recordSet() {
+	if d.meta == nil {
+		return
+	}
+	path := d.currentPath()
+	if _, ok := d.meta.Origins[path]; !ok {
+		d.meta.Set = append(d.meta.Set, path)
+	}
+	d.meta.Origins[path] = Origin{Alias: d.aliasDepth > 0, Merge: d.mergeDepth > 0}
+}
+
+// recordUnused records that the mapping key at the current path
+// matched no struct field.
+func (d *decoder) recordUnused() {
+	if d.meta != nil {
+		d.meta.Unused = append(d.meta.Unused, d.currentPath())
+	}
+}
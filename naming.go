@@ -0,0 +1,81 @@
+package yaml
+
+import (
+	"strings"
+	"unicode"
+)
+
+// A NamingStrategy derives the keys of struct fields from their names,
+// for fields that don't set a key in their tag. Strategies are compared
+// by identity, and the information about a struct is cached separately
+// for each strategy it's used with, so custom strategies should be
+// created once and reused.
+type NamingStrategy struct {
+	key func(fieldName string) string
+}
+
+// NewNamingStrategy returns a strategy that uses key to derive the key
+// of a field from its name.
+func NewNamingStrategy(key func(fieldName string) string) *NamingStrategy {
+	return &NamingStrategy{key}
+}
+
+// The predefined naming strategies. LowerCase is the default, turning a
+// field named MaxRetries into "maxretries". The others split the field
+// name into words at case changes, turning MaxRetries into "maxRetries",
+// "max_retries" and "max-retries" respectively, and HTTPServer into
+// "httpServer", "http_server" and "http-server".
+var (
+	LowerCase = NewNamingStrategy(strings.ToLower)
+	CamelCase = NewNamingStrategy(camelCase)
+	SnakeCase = NewNamingStrategy(func(name string) string { return joinWords(name, "_") })
+	KebabCase = NewNamingStrategy(func(name string) string { return joinWords(name, "-") })
+)
+
+// fieldKey returns the key of the field named name under s,
+// which may be nil to mean LowerCase.
+func (s *NamingStrategy) fieldKey(name string) string {
+	if s == nil {
+		s = LowerCase
+	}
+	return s.key(name)
+}
+
+// splitWords splits name into words before each upper case letter that
+// follows a lower case letter or digit, and before the last letter of a
+// run of upper case letters followed by a lower case one.
+func splitWords(name string) []string {
+	var words []string
+	runes := []rune(name)
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		if !unicode.IsUpper(runes[i]) {
+			continue
+		}
+		prev := runes[i-1]
+		if unicode.IsLower(prev) || unicode.IsDigit(prev) ||
+			unicode.IsUpper(prev) && i+1 < len(runes) && unicode.IsLower(runes[i+1]) {
+			words = append(words, string(runes[start:i]))
+			start = i
+		}
+	}
+	return append(words, string(runes[start:]))
+}
+
+func joinWords(name, sep string) string {
+	return strings.ToLower(strings.Join(splitWords(name), sep))
+}
+
+func camelCase(name string) string {
+	words := splitWords(name)
+	for i, word := range words {
+		word = strings.ToLower(word)
+		if i > 0 {
+			r := []rune(word)
+			r[0] = unicode.ToUpper(r[0])
+			word = string(r)
+		}
+		words[i] = word
+	}
+	return strings.Join(words, "")
+}
@@ -0,0 +1,115 @@
+package yaml
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// A Number is an integer or float scalar, held as its literal text so
+// that no digits are lost, whatever its size or precision.
+//
+// Number values are unmarshalled from integer and float scalars only,
+// and are marshalled back as written. Decoders set to use numbers
+// with SetUseNumber unmarshal all integers and floats into interface{}
+// values as Number.
+type Number string
+
+var numberType = reflect.TypeOf(Number(""))
+
+// String returns the literal text of n.
+func (n Number) String() string {
+	return string(n)
+}
+
+// plain returns the text of n without the underscores that may separate
+// its digits.
+func (n Number) plain() string {
+	return strings.Replace(string(n), "_", "", -1)
+}
+
+// Int64 returns n as an int64, failing if it isn't an integer that fits.
+func (n Number) Int64() (int64, error) {
+	i, err := n.BigInt()
+	if err != nil {
+		return 0, err
+	}
+	if !i.IsInt64() {
+		return 0, errors.New("yaml: number " + string(n) + " overflows int64")
+	}
+	return i.Int64(), nil
+}
+
+// Float64 returns n as the nearest float64, failing if it isn't a number.
+func (n Number) Float64() (float64, error) {
+	if item, ok := resolveMap[string(n)]; ok && item.tag == yaml_FLOAT_TAG {
+		return item.value.(float64), nil
+	}
+	if i, err := n.BigInt(); err == nil {
+		f, _ := new(big.Float).SetInt(i).Float64()
+		return f, nil
+	}
+	f, err := strconv.ParseFloat(n.plain(), 64)
+	if err != nil {
+		return 0, errors.New("yaml: invalid number " + strconv.Quote(string(n)))
+	}
+	return f, nil
+}
+
+// BigInt returns n as a big.Int, failing if it isn't an integer.
+func (n Number) BigInt() (*big.Int, error) {
+	i, ok := new(big.Int).SetString(n.plain(), 0)
+	if !ok {
+		return nil, errors.New("yaml: number " + strconv.Quote(string(n)) + " is not an integer")
+	}
+	return i, nil
+}
+
+// BigFloat returns n as a big.Float, with enough precision to hold all
+// of its digits, failing if it isn't a number or it's not a number
+// (.nan), which big.Float can't represent.
+func (n Number) BigFloat() (*big.Float, error) {
+	if i, err := n.BigInt(); err == nil {
+		return new(big.Float).SetInt(i), nil
+	}
+	if item, ok := resolveMap[string(n)]; ok && item.tag == yaml_FLOAT_TAG {
+		f := item.value.(float64)
+		if math.IsNaN(f) {
+			return nil, errors.New("yaml: cannot represent .nan as a big.Float")
+		}
+		return big.NewFloat(f), nil
+	}
+	plain := n.plain()
+	// Each decimal digit takes less than four bits.
+	prec := uint(4 * len(plain))
+	if prec < 64 {
+		prec = 64
+	}
+	f, _, err := big.ParseFloat(plain, 10, prec, big.ToNearestEven)
+	if err != nil {
+		return nil, errors.New("yaml: invalid number " + strconv.Quote(string(n)))
+	}
+	return f, nil
+}
+
+// isNumberTag returns whether scalars that resolve to tag may be held
+// in a Number.
+func isNumberTag(tag string) bool {
+	return tag == yaml_INT_TAG || tag == yaml_FLOAT_TAG
+}
+
+// numberv emits the literal of the Number in, which must resolve to an
+// integer or float.
+func (e *encoder) numberv(tag string, in reflect.Value) {
+	s := in.String()
+	if s == "" {
+		s = "0"
+	}
+	if rtag, _ := resolve("", s); !isNumberTag(rtag) {
+		failf("cannot marshal invalid number %q", s)
+	}
+	e.emitScalar(s, "", tag, yaml_PLAIN_SCALAR_STYLE)
+}
@@ -0,0 +1,127 @@
+package yaml
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// An OrderedMap is a YAML mapping that keeps its keys in the order in
+// which they were first set, and looks them up by hashing as a Go map
+// does. The zero value is an empty map ready to use.
+//
+// Unmarshalling a mapping into an OrderedMap sets its keys in the order
+// of the document, and the mappings nested in it are unmarshalled into
+// interface{} values as *OrderedMap too. Marshalling an OrderedMap
+// writes its keys in order.
+//
+// Keys must be comparable, as for a map[interface{}]interface{}. An
+// OrderedMap shouldn't be copied after first use; use *OrderedMap
+// values to share it.
+type OrderedMap struct {
+	items []MapItem
+	index map[interface{}]int
+}
+
+var orderedMapType = reflect.TypeOf(OrderedMap{})
+
+// NewOrderedMap returns a map holding items, in order. Later items
+// replace the values of earlier ones with the same key.
+func NewOrderedMap(items ...MapItem) *OrderedMap {
+	m := &OrderedMap{}
+	for _, item := range items {
+		m.Set(item.Key, item.Value)
+	}
+	return m
+}
+
+// Len returns the number of keys in m.
+func (m *OrderedMap) Len() int {
+	return len(m.items)
+}
+
+// Get returns the value of key in m, and whether it's there.
+func (m *OrderedMap) Get(key interface{}) (value interface{}, ok bool) {
+	i, ok := m.index[key]
+	if !ok {
+		return nil, false
+	}
+	return m.items[i].Value, true
+}
+
+// Set sets the value of key in m. New keys are added after all others,
+// while keys already in m keep their place.
+func (m *OrderedMap) Set(key, value interface{}) {
+	if i, ok := m.index[key]; ok {
+		m.items[i].Value = value
+		return
+	}
+	if m.index == nil {
+		m.index = make(map[interface{}]int)
+	}
+	m.index[key] = len(m.items)
+	m.items = append(m.items, MapItem{key, value})
+}
+
+// Delete removes key from m, if it's there.
+func (m *OrderedMap) Delete(key interface{}) {
+	i, ok := m.index[key]
+	if !ok {
+		return
+	}
+	delete(m.index, key)
+	copy(m.items[i:], m.items[i+1:])
+	m.items[len(m.items)-1] = MapItem{}
+	m.items = m.items[:len(m.items)-1]
+	for ; i < len(m.items); i++ {
+		m.index[m.items[i].Key] = i
+	}
+}
+
+// Keys returns the keys of m, in order.
+func (m *OrderedMap) Keys() []interface{} {
+	keys := make([]interface{}, len(m.items))
+	for i, item := range m.items {
+		keys[i] = item.Key
+	}
+	return keys
+}
+
+// Items returns the keys and values of m, in order.
+func (m *OrderedMap) Items() MapSlice {
+	return append(MapSlice(nil), m.items...)
+}
+
+// mappingOrdered unmarshals the mapping n into out, an OrderedMap,
+// keeping the keys that it already holds.
+func (d *decoder) mappingOrdered(n *node, out reflect.Value) (good bool) {
+	m := out.Addr().Interface().(*OrderedMap)
+	mapType := d.mapType
+	d.mapType = orderedMapType
+	for i := 0; i < len(n.children); i += 2 {
+		if isMerge(n.children[i]) {
+			d.merge(n.children[i+1], out)
+			continue
+		}
+		var k interface{}
+		if d.unmarshal(n.children[i], reflect.ValueOf(&k).Elem()) {
+			switch reflect.ValueOf(k).Kind() {
+			case reflect.Map, reflect.Slice, reflect.Ptr:
+				failf("invalid map key: %#v", k)
+			}
+			var v interface{}
+			d.pushPath(fmt.Sprint(k))
+			if d.unmarshal(n.children[i+1], reflect.ValueOf(&v).Elem()) {
+				m.Set(k, v)
+			}
+			d.popPath()
+		}
+	}
+	d.mapType = mapType
+	return true
+}
+
+// orderedv marshals the OrderedMap in as a mapping, in order.
+func (e *encoder) orderedv(tag string, in reflect.Value) {
+	m := in.Interface().(OrderedMap)
+	e.itemsv(tag, reflect.ValueOf(m.items))
+}
@@ -0,0 +1,83 @@
+package yaml
+
+import (
+	"regexp"
+	"strings"
+)
+
+// A QuotingPolicy decides which strings an Encoder writes quoted.
+type QuotingPolicy int
+
+const (
+	// QuoteMinimal quotes the strings that this package would read back
+	// as a different value, such as "true", "1.5" or "null", and those
+	// that look like YAML 1.1 base 60 floats. It's the default.
+	QuoteMinimal QuotingPolicy = iota
+
+	// QuoteCompatible also quotes the strings that any YAML 1.1 or 1.2
+	// parser may read as something other than a string, such as
+	// timestamps like "2001-01-01", octal numbers like "0o17", the
+	// YAML 1.1 value key "=" and the merge key "<<".
+	QuoteCompatible
+
+	// QuoteAlways quotes every string, including mapping keys and
+	// strings with line breaks, which are otherwise written in the
+	// literal style. Strings in struct fields with a scalar style flag
+	// keep that style.
+	QuoteAlways
+)
+
+// A QuoteStyle is the style of the quotes that an Encoder writes.
+type QuoteStyle int
+
+const (
+	// DoubleQuotes is the default quote style.
+	DoubleQuotes QuoteStyle = iota
+
+	// SingleQuotes writes strings in single quotes where possible, and
+	// in double quotes when they hold characters that must be escaped.
+	SingleQuotes
+)
+
+// From http://yaml.org/type/timestamp.html.
+var yaml11Timestamp = regexp.MustCompile(`^[0-9]{4}-[0-9]{1,2}-[0-9]{1,2}` +
+	`(?:(?:[Tt]|[ \t]+)[0-9]{1,2}:[0-9]{2}:[0-9]{2}(?:\.[0-9]*)?` +
+	`(?:[ \t]*(?:Z|[-+][0-9]{1,2}(?::[0-9]{2})?))?)?$`)
+
+// From the core schema of YAML 1.2.
+var yaml12Octal = regexp.MustCompile(`^0o[0-7]+$`)
+
+// isSpecialString returns whether s may be read as something other than
+// a string by YAML 1.1 or 1.2 parsers, other than those that resolve it
+// as this package does.
+func isSpecialString(s string) bool {
+	if s == "=" || s == "<<" {
+		return true
+	}
+	if s == "" || !(s[0] >= '0' && s[0] <= '9') {
+		return false
+	}
+	return yaml12Octal.MatchString(s) || strings.IndexByte(s, '-') >= 0 && yaml11Timestamp.MatchString(s)
+}
+
+// needsQuotes returns whether s must be quoted under the quoting policy
+// of e, given that rtag is the tag it resolves to.
+func (e *encoder) needsQuotes(s, rtag string) bool {
+	switch {
+	case rtag != yaml_STR_TAG || isBase60Float(s):
+		return true
+	case e.quoting == QuoteCompatible:
+		return isSpecialString(s)
+	case e.quoting == QuoteAlways:
+		return true
+	}
+	return false
+}
+
+// quoteStyle returns the scalar style of quoted strings.
+func (e *encoder) quoteStyle() yaml_scalar_style_t {
+	if e.quotes == SingleQuotes {
+		return yaml_SINGLE_QUOTED_SCALAR_STYLE
+	}
+	return yaml_DOUBLE_QUOTED_SCALAR_STYLE
+}
@@ -0,0 +1,150 @@
+package yaml
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+)
+
+// RawMessage is a raw encoded YAML value. It may be used to delay
+// decoding part of a document until its type is known, or to copy
+// a value from one document into another.
+//
+// When unmarshalled into, RawMessage holds a copy of the exact source
+// of the value, including comments, anchors and tags within it. The
+// lines of block mappings and sequences are dedented so that the text
+// can be unmarshalled on its own. A value holding aliases to anchors
+// outside of it can't be unmarshalled into RawMessage, as they couldn't
+// be resolved in its copy. A value that is itself an alias holds the
+// source of the node it refers to.
+//
+// When marshalled, RawMessage is written verbatim, with its lines
+// indented to fit its place in the output. It must hold a single
+// valid YAML document, without directives or "---" and "..." markers
+// or aliases to anchors it doesn't define, and block collections cannot be written within flow collections.
+// An empty RawMessage is marshalled as null.
+type RawMessage []byte
+
+var rawMessageType = reflect.TypeOf(RawMessage(nil))
+
+// raw sets out, a RawMessage, to a copy of the source of n, failing
+// if n holds an alias to an anchor outside of it.
+func (d *decoder) raw(n *node, out reflect.Value) bool {
+	if a := danglingAlias(n, d.doc.anchors); a != nil {
+		d.terrors = append(d.terrors, fmt.Sprintf("line %d: cannot unmarshal alias '%s' to an anchor outside the value into %s", a.line+1, a.value, out.Type()))
+		return false
+	}
+	out.SetBytes(dedent(n.text, n.indent))
+	return true
+}
+
+// danglingAlias returns the first alias in the tree n whose anchor, as
+// found in anchors, isn't within the tree, or nil if there's none.
+func danglingAlias(n *node, anchors map[string]*node) *node {
+	within := make(map[*node]bool)
+	var aliases []*node
+	var walk func(n *node)
+	walk = func(n *node) {
+		within[n] = true
+		if n.kind == aliasNode {
+			aliases = append(aliases, n)
+		}
+		for _, c := range n.children {
+			walk(c)
+		}
+	}
+	walk(n)
+	for _, a := range aliases {
+		if !within[anchors[a.value]] {
+			return a
+		}
+	}
+	return nil
+}
+
+// dedent returns a copy of text with up to n leading spaces removed
+// from each line after the first.
+func dedent(text []byte, n int) []byte {
+	var out []byte
+	for i, line := range bytes.SplitAfter(text, []byte{'\n'}) {
+		if i > 0 {
+			for j := 0; j < n && len(line) > 0 && line[0] == ' '; j++ {
+				line = line[1:]
+			}
+		}
+		out = append(out, line...)
+	}
+	return out
+}
+
+// minIndent returns the least number of spaces that the non-blank
+// lines of text after the first are indented by.
+func minIndent(text []byte) int {
+	min := -1
+	for i, line := range bytes.Split(text, []byte{'\n'}) {
+		indent := len(line) - len(bytes.TrimLeft(line, " "))
+		if i > 0 && indent < len(line) && (min < 0 || indent < min) {
+			min = indent
+		}
+	}
+	return min
+}
+
+// rawv emits the raw YAML text in in after checking that it holds a
+// single document.
+func (e *encoder) rawv(in reflect.Value) {
+	text := in.Bytes()
+	if len(bytes.TrimSpace(text)) == 0 {
+		e.nilv()
+		return
+	}
+	// The flow option has no effect on raw values.
+	e.flow = false
+	text = bytes.TrimRight(text, "\n")
+	style := rawStyle(text)
+	if style == 0 {
+		// Other than in block collections, the indentation of
+		// continuation lines is only relative to the enclosing
+		// block, so it's replaced by the one of the output.
+		text = dedent(text, minIndent(text))
+	}
+	e.must(yaml_raw_event_initialize(&e.event, text, style))
+	e.emit()
+}
+
+// rawStyle returns the block style of the collection in text, if any,
+// failing if text isn't a single valid document.
+func rawStyle(text []byte) (style yaml_style_t) {
+	p := newParser(text)
+	defer p.destroy()
+	p.init()
+	if p.event.typ != yaml_DOCUMENT_START_EVENT {
+		failf("invalid RawMessage: no document found")
+	}
+	if !p.event.implicit {
+		failf("invalid RawMessage: directives and document markers are not allowed")
+	}
+	p.skip()
+	switch p.event.typ {
+	case yaml_SEQUENCE_START_EVENT:
+		if p.event.sequence_style() == yaml_BLOCK_SEQUENCE_STYLE {
+			style = yaml_style_t(yaml_BLOCK_SEQUENCE_STYLE)
+		}
+	case yaml_MAPPING_START_EVENT:
+		if p.event.mapping_style() == yaml_BLOCK_MAPPING_STYLE {
+			style = yaml_style_t(yaml_BLOCK_MAPPING_STYLE)
+		}
+	}
+	p.doc = &node{anchors: make(map[string]*node)}
+	if a := danglingAlias(p.parse(), p.doc.anchors); a != nil {
+		failf("invalid RawMessage: unknown anchor '%s' referenced", a.value)
+	}
+	if !p.event.implicit {
+		failf("invalid RawMessage: directives and document markers are not allowed")
+	}
+	p.skip()
+	if p.event.typ != yaml_STREAM_END_EVENT {
+		failf("invalid RawMessage: more than one document found")
+	}
+	return style
+}
@@ -125,6 +125,7 @@ func yaml_parser_update_buffer(parser *yaml_parser_t, length int) bool {
 	parser.buffer = parser.buffer[:cap(parser.buffer)]
 
 	// Fill the buffer until it has enough characters.
+	captured := buffer_len
 	first := true
 	for parser.unread < length {
 
@@ -378,6 +379,12 @@ func yaml_parser_update_buffer(parser *yaml_parser_t, length int) bool {
 			parser.unread++
 		}
 
+		// [Go] Capture the decoded characters.
+		if parser.capture_source {
+			parser.source = append(parser.source, parser.buffer[captured:buffer_len]...)
+			captured = buffer_len
+		}
+
 		// On EOF, put NUL into the buffer and return.
 		if parser.eof {
 			parser.buffer[buffer_len] = 0
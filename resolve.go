@@ -5,6 +5,7 @@ import (
 	"math"
 	"strconv"
 	"strings"
+	"sync"
 	"unicode/utf8"
 )
 
@@ -177,6 +178,83 @@ func resolve(tag string, in string) (rtag string, out interface{}) {
 	return yaml_BINARY_TAG, encodeBase64(in)
 }
 
+// A Resolver recognizes plain scalars of a custom implicit type, in
+// addition to the null, bool, int and float values recognized by
+// default. See RegisterResolver.
+type Resolver struct {
+	// Hint holds the characters that a scalar recognized by the
+	// resolver may start with. Resolve is only called for plain
+	// scalars starting with one of them, or for every plain scalar
+	// if Hint is empty.
+	Hint string
+
+	// Resolve returns the tag and the value of the plain scalar s,
+	// and whether the resolver recognized it at all.
+	Resolve func(s string) (tag string, value interface{}, ok bool)
+}
+
+var globalResolvers []*Resolver
+var resolverMutex sync.RWMutex
+
+// RegisterResolver registers r to resolve plain scalars for Unmarshal,
+// Marshal, and every Encoder and Decoder. Resolvers registered on a
+// specific Encoder or Decoder are tried first, then the ones registered
+// globally, in registration order, and only then the default resolution.
+//
+// When decoding, the value returned by a matching resolver is stored
+// into interface{} values and values of its own type. When encoding,
+// strings that a resolver would recognize are quoted so they read back
+// as strings, unless they are the marshaled form of a value of the very
+// type the resolver produces.
+func RegisterResolver(r Resolver) {
+	resolverMutex.Lock()
+	globalResolvers = append(globalResolvers, &r)
+	resolverMutex.Unlock()
+}
+
+type resolverList []*Resolver
+
+func (l *resolverList) register(r Resolver) {
+	*l = append(*l, &r)
+}
+
+// match returns the result of the first resolver in l or among the
+// global ones that recognizes in.
+func (l resolverList) match(in string) (tag string, out interface{}, ok bool) {
+	if tag, out, ok = matchResolvers(l, in); ok {
+		return tag, out, ok
+	}
+	resolverMutex.RLock()
+	global := globalResolvers
+	resolverMutex.RUnlock()
+	return matchResolvers(global, in)
+}
+
+func matchResolvers(l []*Resolver, in string) (tag string, out interface{}, ok bool) {
+	for _, r := range l {
+		if r.Hint != "" && strings.IndexByte(r.Hint, in[0]) < 0 {
+			continue
+		}
+		if tag, out, ok = r.Resolve(in); ok {
+			return longTag(tag), out, true
+		}
+	}
+	return "", nil, false
+}
+
+// resolve is like the resolve function, but tries the resolvers in l
+// before the default resolution of untagged scalars. The custom result
+// is true if one of them recognized the scalar.
+func (l resolverList) resolve(tag string, in string) (rtag string, out interface{}, custom bool) {
+	if tag == "" && in != "" {
+		if rtag, out, ok := l.match(in); ok {
+			return rtag, out, true
+		}
+	}
+	rtag, out = resolve(tag, in)
+	return rtag, out, false
+}
+
 // encodeBase64 encodes s as base64 that is broken up into multiple lines
 // as appropriate for the resulting length.
 func encodeBase64(s string) string {
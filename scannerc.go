@@ -489,25 +489,30 @@ func cache(parser *yaml_parser_t, length int) bool {
 
 // Advance the buffer pointer.
 func skip(parser *yaml_parser_t) {
+	w := width(parser.buffer[parser.buffer_pos])
 	parser.mark.index++
+	parser.mark.source_index += w
 	parser.mark.column++
 	parser.unread--
-	parser.buffer_pos += width(parser.buffer[parser.buffer_pos])
+	parser.buffer_pos += w
 }
 
 func skip_line(parser *yaml_parser_t) {
 	if is_crlf(parser.buffer, parser.buffer_pos) {
 		parser.mark.index += 2
+		parser.mark.source_index += 2
 		parser.mark.column = 0
 		parser.mark.line++
 		parser.unread -= 2
 		parser.buffer_pos += 2
 	} else if is_break(parser.buffer, parser.buffer_pos) {
+		w := width(parser.buffer[parser.buffer_pos])
 		parser.mark.index++
+		parser.mark.source_index += w
 		parser.mark.column = 0
 		parser.mark.line++
 		parser.unread--
-		parser.buffer_pos += width(parser.buffer[parser.buffer_pos])
+		parser.buffer_pos += w
 	}
 }
 
@@ -529,6 +534,7 @@ func read(parser *yaml_parser_t, s []byte) []byte {
 		parser.buffer_pos += w
 	}
 	parser.mark.index++
+	parser.mark.source_index += w
 	parser.mark.column++
 	parser.unread--
 	return s
@@ -561,6 +567,7 @@ func read_line(parser *yaml_parser_t, s []byte) []byte {
 		return s
 	}
 	parser.mark.index++
+	parser.mark.source_index += parser.buffer_pos - pos
 	parser.mark.column = 0
 	parser.mark.line++
 	parser.unread--
@@ -2392,6 +2399,8 @@ func yaml_parser_scan_flow_scalar(parser *yaml_parser_t, token *yaml_token_t, si
 					s = append(s, '\'')
 				case '\\':
 					s = append(s, '\\')
+				case '/': // [Go] As in YAML 1.2 and JSON.
+					s = append(s, '/')
 				case 'N': // NEL (#x85)
 					s = append(s, '\xC2')
 					s = append(s, '\x85')
@@ -1,6 +1,7 @@
 package yaml
 
 import (
+	"fmt"
 	"reflect"
 	"unicode"
 )
@@ -102,3 +103,68 @@ func numLess(a, b reflect.Value) bool {
 	}
 	panic("not a number")
 }
+
+// A KeyOrder orders the keys of the maps that an Encoder marshals, by
+// reporting whether the key a is written before the key b.
+type KeyOrder func(a, b interface{}) bool
+
+// NaturalOrder is the default KeyOrder. Numbers and booleans come
+// before other keys, in numeric order, and strings are in lexical
+// order, except that runs of digits in them compare as numbers, so
+// that "a2" comes before "a10".
+func NaturalOrder(a, b interface{}) bool {
+	return keyList{reflect.ValueOf(&a).Elem(), reflect.ValueOf(&b).Elem()}.Less(0, 1)
+}
+
+// LexicalOrder orders keys by their text, as formatted by fmt.Sprint,
+// byte by byte. Keys with the same text are in natural order.
+func LexicalOrder(a, b interface{}) bool {
+	as, bs := fmt.Sprint(a), fmt.Sprint(b)
+	if as != bs {
+		return as < bs
+	}
+	return NaturalOrder(a, b)
+}
+
+// KeysFirst returns a KeyOrder that writes the given keys first, in the
+// order they are listed, and all others after them in natural order.
+// The keys must be comparable.
+func KeysFirst(keys ...interface{}) KeyOrder {
+	rank := make(map[interface{}]int, len(keys))
+	for i, k := range keys {
+		if _, ok := rank[k]; !ok {
+			rank[k] = i
+		}
+	}
+	return func(a, b interface{}) bool {
+		ai, aok := rank[a]
+		bi, bok := rank[b]
+		if aok && bok {
+			return ai < bi
+		}
+		if aok || bok {
+			return aok
+		}
+		return NaturalOrder(a, b)
+	}
+}
+
+// keySorter sorts map keys by the values in by, which hold the keys
+// themselves or their text, with less, or in natural order if it's nil.
+type keySorter struct {
+	keys []reflect.Value
+	by   []reflect.Value
+	less KeyOrder
+}
+
+func (l keySorter) Len() int { return len(l.keys) }
+func (l keySorter) Swap(i, j int) {
+	l.keys[i], l.keys[j] = l.keys[j], l.keys[i]
+	l.by[i], l.by[j] = l.by[j], l.by[i]
+}
+func (l keySorter) Less(i, j int) bool {
+	if l.less == nil {
+		return keyList(l.by).Less(i, j)
+	}
+	return l.less(l.by[i].Interface(), l.by[j].Interface())
+}
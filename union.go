@@ -0,0 +1,177 @@
+package yaml
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// A Union describes the concrete types that values of an interface type
+// may hold, told apart by the value of a discriminator key in the
+// mappings that represent them.
+//
+// For example, with
+//
+//     yaml.RegisterUnion(reflect.TypeOf((*Shape)(nil)).Elem(), yaml.Union{
+//         Key: "kind",
+//         Types: map[string]reflect.Type{
+//             "circle": reflect.TypeOf(Circle{}),
+//             "square": reflect.TypeOf(&Square{}),
+//         },
+//     })
+//
+// a mapping with "kind: circle" is unmarshalled into a Shape value as a
+// Circle, and marshalling a Shape holding a *Square writes "kind: square"
+// as the first key of the mapping.
+type Union struct {
+	// Key is the mapping key holding the name of the concrete type.
+	Key string
+
+	// Types maps the names in Key to the concrete types, which must
+	// implement the interface, each for a single name. Values of
+	// pointer types are allocated when unmarshalling.
+	Types map[string]reflect.Type
+}
+
+type unionMap map[reflect.Type]*Union
+
+var globalUnions = make(unionMap)
+var unionMutex sync.RWMutex
+
+// RegisterUnion registers u to select the concrete type of the values of
+// the interface type t for Marshal, Unmarshal, and every Encoder and
+// Decoder. Unions registered on a specific Encoder or Decoder take
+// precedence.
+//
+// When unmarshalling a mapping into a value of type t, the concrete
+// type is chosen by the value of u.Key, and the whole mapping is then
+// unmarshalled into it, including u.Key itself. It's an error for the
+// key to be missing or to hold an unknown name.
+//
+// When marshalling a value of type t, u.Key is written as the first key
+// of the mapping, with the name of the type of the value. A struct field
+// of the value for that key is written in its own place instead if it's
+// set to that name, and is left out otherwise. A map holding the key
+// writes it in its own place too, but it's an error for it to hold
+// another name. It's an error for the type not to be in u.Types, or not
+// to be marshalled as a mapping.
+//
+// RegisterUnion panics if t isn't an interface type, if any of the types
+// in u doesn't implement it, or if a type is registered for more than one
+// name, as marshalling couldn't choose between them.
+func RegisterUnion(t reflect.Type, u Union) {
+	checkUnion(t, u)
+	unionMutex.Lock()
+	globalUnions[t] = &u
+	unionMutex.Unlock()
+}
+
+func checkUnion(t reflect.Type, u Union) {
+	if t.Kind() != reflect.Interface {
+		panic("yaml: union type " + t.String() + " is not an interface")
+	}
+	names := make([]string, 0, len(u.Types))
+	for name := range u.Types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	seen := make(map[reflect.Type]string)
+	for _, name := range names {
+		ut := u.Types[name]
+		if !ut.Implements(t) {
+			panic(fmt.Sprintf("yaml: type %s of union %s for %q doesn't implement it", ut, t, name))
+		}
+		if other, ok := seen[ut]; ok {
+			panic(fmt.Sprintf("yaml: type %s of union %s is registered for both %q and %q", ut, t, other, name))
+		}
+		seen[ut] = name
+	}
+}
+
+// lookup returns the union registered for t in m or globally,
+// or nil if there's none.
+func (m unionMap) lookup(t reflect.Type) *Union {
+	if u, ok := m[t]; ok {
+		return u
+	}
+	unionMutex.RLock()
+	u := globalUnions[t]
+	unionMutex.RUnlock()
+	return u
+}
+
+func (m *unionMap) register(t reflect.Type, u Union) {
+	checkUnion(t, u)
+	if *m == nil {
+		*m = make(unionMap)
+	}
+	(*m)[t] = &u
+}
+
+// name returns the name that t is registered with in u.
+func (u *Union) name(t reflect.Type) (name string, ok bool) {
+	for name, ut := range u.Types {
+		if ut == t {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// union unmarshals the mapping n into out, a value of an interface type
+// registered with u, choosing its concrete type by the discriminator.
+func (d *decoder) union(n *node, out reflect.Value, u *Union) (good bool) {
+	var name *node
+	for i := 0; i+1 < len(n.children); i += 2 {
+		k := n.children[i]
+		if k.kind == scalarNode && k.value == u.Key {
+			name = n.children[i+1]
+			break
+		}
+	}
+	if name == nil {
+		d.terrors = append(d.terrors, fmt.Sprintf("line %d: missing key %q to select the type of %s", n.line+1, u.Key, out.Type()))
+		return false
+	}
+	if name.kind == aliasNode {
+		if an, ok := d.doc.anchors[name.value]; ok {
+			name = an
+		}
+	}
+	if name.kind != scalarNode {
+		d.terrors = append(d.terrors, fmt.Sprintf("line %d: %s must be a scalar to select the type of %s", name.line+1, u.Key, out.Type()))
+		return false
+	}
+	t, ok := u.Types[name.value]
+	if !ok {
+		d.terrors = append(d.terrors, fmt.Sprintf("line %d: unknown %s %q for %s", name.line+1, u.Key, name.value, out.Type()))
+		return false
+	}
+	var v reflect.Value
+	if t.Kind() == reflect.Ptr {
+		v = reflect.New(t.Elem())
+		good = d.unmarshal(n, v.Elem())
+	} else {
+		v = reflect.New(t).Elem()
+		good = d.unmarshal(n, v)
+	}
+	out.Set(v)
+	return good
+}
+
+// unionv marshals in, a value of an interface type registered with u,
+// writing the discriminator as the first key of its mapping.
+func (e *encoder) unionv(tag string, in reflect.Value, u *Union) {
+	if v := in.Elem(); v.Kind() == reflect.Ptr && v.IsNil() {
+		e.nilv()
+		return
+	}
+	t := in.Elem().Type()
+	name, ok := u.name(t)
+	if !ok {
+		failf("cannot marshal %s as %s: type is not in its union", t, in.Type())
+	}
+	e.discriminator = &MapItem{u.Key, name}
+	e.marshal(tag, in.Elem())
+}
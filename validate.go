@@ -0,0 +1,178 @@
+package yaml
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The Validator interface may be implemented by types to check their
+// own consistency once they are unmarshaled from a YAML mapping. The
+// ValidateYAML method is called after all fields of the struct are
+// set, including default values.
+//
+// A *TypeError returned by ValidateYAML has its errors added to the
+// ones reported by Unmarshal, while other errors are reported together
+// with the line of the mapping. Either way, decoding continues.
+type Validator interface {
+	ValidateYAML() error
+}
+
+// fieldChecks holds the constraints declared in the tag of a field,
+// which are verified against the values decoded from the document.
+type fieldChecks struct {
+	Min, Max *float64
+	OneOf    []string
+	Pattern  *regexp.Regexp
+}
+
+// parseCheck parses flag into info if it is a validation option,
+// and returns whether it is.
+func (info *fieldInfo) parseCheck(flag string, t reflect.Type) (ok bool, err error) {
+	i := strings.Index(flag, "=")
+	if i < 0 {
+		return false, nil
+	}
+	name, arg := flag[:i], flag[i+1:]
+	if name != "min" && name != "max" && name != "oneof" && name != "pattern" {
+		return false, nil
+	}
+	if info.Checks == nil {
+		info.Checks = &fieldChecks{}
+	}
+	c := info.Checks
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch name {
+	case "min", "max":
+		if _, ok := checkedNumber(reflect.Zero(t)); !ok {
+			return true, errors.New("option " + name + "= needs a number, string, slice or map field")
+		}
+		f, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return true, fmt.Errorf("invalid number in option %s", flag)
+		}
+		if name == "min" {
+			c.Min = &f
+		} else {
+			c.Max = &f
+		}
+	case "oneof":
+		c.OneOf = strings.Fields(arg)
+	case "pattern":
+		if err := info.setPattern(arg, t); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+func (info *fieldInfo) setPattern(pattern string, t reflect.Type) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.String {
+		return errors.New("option pattern= needs a string field")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	if info.Checks == nil {
+		info.Checks = &fieldChecks{}
+	}
+	info.Checks.Pattern = re
+	return nil
+}
+
+// checkedNumber returns the number that min= and max= are compared
+// against for v: the value itself for numbers, or the length of
+// strings, slices and maps.
+func checkedNumber(v reflect.Value) (f float64, ok bool) {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return float64(v.Len()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+// check verifies that the value decoded from n into field satisfies
+// the constraints in info, and records a type error for each that
+// it doesn't.
+func (d *decoder) check(n *node, info *fieldInfo, field reflect.Value) {
+	for field.Kind() == reflect.Ptr || field.Kind() == reflect.Interface {
+		if field.IsNil() {
+			return
+		}
+		field = field.Elem()
+	}
+	c := info.Checks
+	if c.Min != nil || c.Max != nil {
+		f, _ := checkedNumber(field)
+		what := "value"
+		switch field.Kind() {
+		case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+			what = "length"
+		}
+		if c.Min != nil && f < *c.Min {
+			d.terrors = append(d.terrors, fmt.Sprintf("line %d: %s of %q must be at least %v, got %v", n.line+1, what, info.Key, *c.Min, f))
+		}
+		if c.Max != nil && f > *c.Max {
+			d.terrors = append(d.terrors, fmt.Sprintf("line %d: %s of %q must be at most %v, got %v", n.line+1, what, info.Key, *c.Max, f))
+		}
+	}
+	if c.OneOf != nil {
+		s := fmt.Sprint(field.Interface())
+		found := false
+		for _, allowed := range c.OneOf {
+			if s == allowed {
+				found = true
+				break
+			}
+		}
+		if !found {
+			d.terrors = append(d.terrors, fmt.Sprintf("line %d: %q must be one of %s, got %q", n.line+1, info.Key, strings.Join(c.OneOf, ", "), s))
+		}
+	}
+	if c.Pattern != nil && !c.Pattern.MatchString(field.String()) {
+		d.terrors = append(d.terrors, fmt.Sprintf("line %d: %q must match %s, got %q", n.line+1, info.Key, c.Pattern, field.String()))
+	}
+}
+
+// checkRequired records a type error at the line of the mapping n for
+// each required field of the struct that isn't in set.
+func (d *decoder) checkRequired(n *node, out reflect.Value, sinfo *structInfo, set map[string]bool) {
+	for _, info := range sinfo.FieldsList {
+		if info.Required && !set[info.Key] {
+			d.terrors = append(d.terrors, fmt.Sprintf("line %d: missing required key %q in %s", n.line+1, info.Key, out.Type()))
+		}
+	}
+}
+
+// validate calls ValidateYAML on the struct out decoded from the
+// mapping n if it implements Validator.
+func (d *decoder) validate(n *node, out reflect.Value) {
+	if !out.CanAddr() {
+		return
+	}
+	v, ok := out.Addr().Interface().(Validator)
+	if !ok {
+		return
+	}
+	err := v.ValidateYAML()
+	if e, ok := err.(*TypeError); ok {
+		d.terrors = append(d.terrors, e.Errors...)
+	} else if err != nil {
+		d.terrors = append(d.terrors, fmt.Sprintf("line %d: %v", n.line+1, err))
+	}
+}
@@ -7,8 +7,10 @@
 package yaml
 
 import (
+	"encoding"
 	"errors"
 	"fmt"
+	"io"
 	"reflect"
 	"strings"
 	"sync"
@@ -42,6 +44,13 @@ type Marshaler interface {
 	MarshalYAML() (interface{}, error)
 }
 
+// IsZeroer is used to check whether an object is zero to determine
+// whether it should be omitted when marshaling with the omitempty or
+// omitzero flags. One notable implementation is time.Time.
+type IsZeroer interface {
+	IsZero() bool
+}
+
 // Unmarshal decodes the first document found within the in byte slice
 // and assigns decoded values into the out value.
 //
@@ -56,6 +65,13 @@ type Marshaler interface {
 // content, and a *yaml.TypeError is returned with details for all
 // missed values.
 //
+// Double-quoted scalars may use the \/ escape for a slash, as in YAML 1.2
+// and JSON, so that any JSON document can be unmarshalled.
+//
+// Map keys of types implementing encoding.TextUnmarshaler are
+// unmarshalled from the text of plain scalars as it is written, even
+// when it reads as a number, boolean or other non-string value.
+//
 // Struct fields are only unmarshalled if they are exported (have an
 // upper case first letter), and are unmarshalled using the field name
 // lowercased as the default key. Custom keys may be defined via the
@@ -77,18 +93,197 @@ type Marshaler interface {
 // supported tag options.
 //
 func Unmarshal(in []byte, out interface{}) (err error) {
-	defer handleErr(&err)
+	return unmarshal(in, out, newDecoder())
+}
+
+// UnmarshalWithMetadata works like Unmarshal, but also returns metadata
+// describing which struct fields were set by the document, which keys
+// didn't match any field, and how the values were obtained.
+// The metadata is returned even if decoding fails with a *TypeError.
+func UnmarshalWithMetadata(in []byte, out interface{}) (md *Metadata, err error) {
 	d := newDecoder()
+	d.meta = &Metadata{Origins: make(map[string]Origin)}
+	err = unmarshal(in, out, d)
+	return d.meta, err
+}
+
+func unmarshal(in []byte, out interface{}, d *decoder) (err error) {
+	defer handleErr(&err)
 	p := newParser(in)
 	defer p.destroy()
 	node := p.parse()
+	v := reflect.ValueOf(out)
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
 	if node != nil {
-		v := reflect.ValueOf(out)
-		if v.Kind() == reflect.Ptr && !v.IsNil() {
-			v = v.Elem()
-		}
 		d.unmarshal(node, v)
+	} else {
+		d.documentDefaults(nil, v)
+	}
+	if len(d.terrors) > 0 {
+		return &TypeError{d.terrors}
+	}
+	return nil
+}
+
+// A Decoder reads and decodes YAML documents from an input stream.
+type Decoder struct {
+	parser *parser
+	opts   decodeOptions
+}
+
+// NewDecoder returns a new decoder that reads from r.
+//
+// The decoder introduces its own buffering and may read
+// data from r beyond the YAML documents requested.
+// Unlike an Encoder, a Decoder holds nothing that needs releasing
+// once it's no longer used, so it has no Close method. It never
+// closes r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{parser: newParserFromReader(r)}
+}
+
+// RegisterCodec registers c to be used for values of type t decoded by
+// dec, taking precedence over codecs registered globally.
+// See the package-level RegisterCodec for details.
+func (dec *Decoder) RegisterCodec(t reflect.Type, c Codec) {
+	dec.opts.codecs.register(t, c)
+}
+
+// RegisterResolver registers r to resolve plain scalars decoded by dec,
+// ahead of the resolvers registered globally.
+// See the package-level RegisterResolver for details.
+func (dec *Decoder) RegisterResolver(r Resolver) {
+	dec.opts.resolvers.register(r)
+}
+
+// RegisterUnion registers u to select the concrete type of values of the
+// interface type t decoded by dec, ahead of the unions registered globally.
+// See the package-level RegisterUnion for details.
+func (dec *Decoder) RegisterUnion(t reflect.Type, u Union) {
+	dec.opts.unions.register(t, u)
+}
+
+// AddDecodeHook appends h to the hooks that dec calls to convert scalar
+// values that don't fit the type they are decoded into. The hooks are
+// called in the order they were added, until one of them returns a value
+// that is assignable or convertible to the target type. Only if none
+// does is the mismatch reported in the resulting *TypeError.
+func (dec *Decoder) AddDecodeHook(h DecodeHook) {
+	dec.opts.hooks = append(dec.opts.hooks, h)
+}
+
+// SetWeaklyTyped enables or disables weakly-typed decoding, which
+// accepts values that would otherwise be reported as type errors:
+// numbers and booleans written as strings are parsed into numeric and
+// bool values, and a single value is decoded as a one-element slice.
+// These conversions, as well as numbers and booleans decoded into
+// strings, are reported to the warning handler.
+func (dec *Decoder) SetWeaklyTyped(weak bool) {
+	dec.opts.weak = weak
+}
+
+// SetJSONFallback enables or disables the use of JSON conventions for
+// types that don't have YAML-specific ones. When enabled, struct fields
+// without a yaml tag use their json tag instead, including the "-" name
+// and the omitempty option, and types that implement json.Unmarshaler
+// are decoded by converting their value to JSON, unless they implement
+// yaml.Unmarshaler or encoding.TextUnmarshaler, which take precedence.
+func (dec *Decoder) SetJSONFallback(enabled bool) {
+	dec.opts.jsonTags = enabled
+}
+
+// SetNamingStrategy sets the strategy that derives the keys of struct
+// fields that don't set one in their tag. The default is LowerCase.
+func (dec *Decoder) SetNamingStrategy(s *NamingStrategy) {
+	dec.opts.naming = s
+}
+
+// SetAutoInline enables or disables the inlining of embedded structs
+// and pointers to structs that don't set a key in their tag, as with
+// the inline option, following the conventions of encoding/json: fields
+// inlined from several structs may share a key, in which case the one
+// in the least deeply embedded struct is used, or the only one among
+// several at that depth that sets the key in its tag. If there's no
+// such field, none of them is used. Nil embedded pointers are allocated
+// when one of their fields is set.
+func (dec *Decoder) SetAutoInline(enabled bool) {
+	dec.opts.autoInline = enabled
+}
+
+// SetCaseInsensitive enables or disables case-insensitive matching of
+// mapping keys to struct fields. When enabled, a key that matches no
+// field or alias exactly matches the one that it equals regardless of
+// case, if there's only one such field or alias.
+func (dec *Decoder) SetCaseInsensitive(enabled bool) {
+	dec.opts.caseInsensitive = enabled
+}
+
+// SetUseNumber sets whether integers and floats are decoded into
+// interface{} values as Number, keeping their literal text, instead
+// of as int, int64, uint64 or float64.
+func (dec *Decoder) SetUseNumber(enabled bool) {
+	dec.opts.useNumber = enabled
+}
+
+// SetUseInt64 sets whether integers are decoded into interface{}
+// values as int64 rather than as int. Integers that only fit in a
+// uint64 are still decoded as uint64.
+func (dec *Decoder) SetUseInt64(enabled bool) {
+	dec.opts.useInt64 = enabled
+}
+
+// SetMapType sets the Go type of the mappings that dec decodes into
+// interface{} values, at any depth. As without it, the mappings nested
+// in a map[interface{}]interface{}, MapSlice or OrderedMap are decoded
+// as the mapping that holds them. The default is InterfaceMaps.
+func (dec *Decoder) SetMapType(t MapType) {
+	dec.opts.maps = t
+}
+
+// SetWarningHandler sets a function that dec calls with a message for
+// every problem that doesn't prevent a value from being decoded.
+func (dec *Decoder) SetWarningHandler(h func(msg string)) {
+	dec.opts.warnings = h
+}
+
+// Decode reads the next YAML document from its input and stores it
+// in the value pointed to by v. It returns io.EOF when there are no
+// more documents in the stream.
+//
+// See the documentation for Unmarshal for details about the
+// conversion of YAML into a Go value.
+func (dec *Decoder) Decode(v interface{}) (err error) {
+	d := newDecoder()
+	d.decodeOptions = dec.opts
+	return dec.decode(v, d)
+}
+
+// DecodeWithMetadata works like Decode, but also returns metadata
+// describing which struct fields were set by the document, which keys
+// didn't match any field, and how the values were obtained.
+// The metadata is returned even if decoding fails with a *TypeError.
+func (dec *Decoder) DecodeWithMetadata(v interface{}) (md *Metadata, err error) {
+	d := newDecoder()
+	d.decodeOptions = dec.opts
+	d.meta = &Metadata{Origins: make(map[string]Origin)}
+	err = dec.decode(v, d)
+	return d.meta, err
+}
+
+func (dec *Decoder) decode(v interface{}, d *decoder) (err error) {
+	defer handleErr(&err)
+	node := dec.parser.parse()
+	if node == nil {
+		return io.EOF
+	}
+	out := reflect.ValueOf(v)
+	if out.Kind() == reflect.Ptr && !out.IsNil() {
+		out = out.Elem()
 	}
+	d.mapType = d.maps.reflectType()
+	d.unmarshal(node, out)
 	if len(d.terrors) > 0 {
 		return &TypeError{d.terrors}
 	}
@@ -114,15 +309,71 @@ func Unmarshal(in []byte, out interface{}) (err error) {
 //
 //     omitempty    Only include the field if it's not set to the zero
 //                  value for the type or to empty slices or maps.
-//                  Does not apply to zero valued structs.
+//                  Structs are empty if all of their exported fields
+//                  are. Values implementing IsZeroer are empty if
+//                  their IsZero method says so.
+//
+//     omitzero     Only include the field if it's not set to the zero
+//                  value for the type, as decided by its IsZero method
+//                  for values implementing IsZeroer. Unlike omitempty,
+//                  private fields of structs are taken into account,
+//                  and empty but non-nil slices and maps are included.
 //
 //     flow         Marshal using a flow style (useful for structs,
 //                  sequences and maps).
 //
-//     inline       Inline the field, which must be a struct or a map,
-//                  causing all of its fields or keys to be processed as if
-//                  they were part of the outer struct. For maps, keys must
-//                  not conflict with the yaml keys of other struct fields.
+//     hex          Marshal integers in the given base, with the 0x, 0
+//     octal        or 0b prefix respectively, as in 0x1a4, 0644 or
+//     binary       0b110100100.
+//
+//     prec=<n>     Marshal floats with <n> decimals instead of as few
+//                  as needed to read back the same value.
+//
+//     literal      Marshal strings using the given scalar style. If the
+//     folded       style can't represent the value or can't be used in
+//     singlequoted its place, such as block styles within flow
+//     doublequoted collections, the string is double-quoted instead.
+//
+//     inline       Inline the field, which must be a struct, a pointer to
+//                  a struct or a map, causing all of its fields or keys to
+//                  be processed as if they were part of the outer struct.
+//                  Nil pointers are allocated when unmarshalling one of
+//                  their fields, and left out when marshalling. Maps must
+//                  have string, interface{} or encoding.TextUnmarshaler
+//                  keys, which must not conflict with the yaml keys of
+//                  other struct fields.
+//
+//     required     When unmarshalling, report an error if the mapping
+//                  being decoded doesn't set the field.
+//
+//     min=<n>      When unmarshalling, report an error if the value of
+//     max=<n>      the field, or its length for strings, slices and
+//                  maps, is less than or greater than <n>.
+//
+//     oneof=<v...> When unmarshalling, report an error if the value of
+//                  the field isn't one of the space-separated values.
+//
+//     pattern=<re> When unmarshalling, report an error if the string
+//                  value of the field doesn't match the regular
+//                  expression <re>. Expressions containing commas may
+//                  be provided in a separate `pattern:"<re>"` tag.
+//
+//     position     When unmarshalling, set the field to the position of
+//                  the mapping in the document, or of each of its keys.
+//                  See the Position type. Not allowed in inlined structs.
+//
+//     default=<v>  When unmarshalling, decode <v> into the field if the
+//                  mapping being decoded doesn't set it. The value is
+//                  parsed as YAML, so it is resolved like the document
+//                  itself. Values containing commas may be provided in
+//                  a separate `default:"<v>"` tag instead. Fields of
+//                  struct values that are not set at all receive their
+//                  defaults too, as do structs that an empty or null
+//                  document is unmarshalled into.
+//
+//     alias=<key>  When unmarshalling, also accept <key> for the field,
+//                  reporting its use to the warning handler of the
+//                  Decoder as deprecated. May be given multiple times.
 //
 // In addition, if the key is "-", the field is ignored.
 //
@@ -139,12 +390,140 @@ func Marshal(in interface{}) (out []byte, err error) {
 	defer handleErr(&err)
 	e := newEncoder()
 	defer e.destroy()
-	e.marshal("", reflect.ValueOf(in))
+	e.marshalDoc("", reflect.ValueOf(in))
 	e.finish()
 	out = e.out
 	return
 }
 
+// An Encoder writes YAML documents to an output stream.
+type Encoder struct {
+	encoder *encoder
+}
+
+// NewEncoder returns a new encoder that writes to w.
+// The Encoder should be closed after use to flush all data to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{
+		encoder: newEncoderWithWriter(w),
+	}
+}
+
+// RegisterCodec registers c to be used for values of type t encoded by
+// enc, taking precedence over codecs registered globally.
+// See the package-level RegisterCodec for details.
+func (enc *Encoder) RegisterCodec(t reflect.Type, c Codec) {
+	enc.encoder.codecs.register(t, c)
+}
+
+// RegisterResolver registers r to decide which strings encoded by enc
+// must be quoted, ahead of the resolvers registered globally.
+// See the package-level RegisterResolver for details.
+func (enc *Encoder) RegisterResolver(r Resolver) {
+	enc.encoder.resolvers.register(r)
+}
+
+// RegisterUnion registers u to write the discriminator of values of the
+// interface type t encoded by enc, ahead of the unions registered globally.
+// See the package-level RegisterUnion for details.
+func (enc *Encoder) RegisterUnion(t reflect.Type, u Union) {
+	enc.encoder.unions.register(t, u)
+}
+
+// SetJSONFallback enables or disables the use of JSON conventions for
+// types that don't have YAML-specific ones. When enabled, struct fields
+// without a yaml tag use their json tag instead, including the "-" name
+// and the omitempty option, and types that implement json.Marshaler are
+// encoded by converting their JSON output to YAML, unless they implement
+// yaml.Marshaler or encoding.TextMarshaler, which take precedence. That
+// output is parsed as YAML, which accepts all JSON escapes, including \/.
+func (enc *Encoder) SetJSONFallback(enabled bool) {
+	enc.encoder.jsonTags = enabled
+}
+
+// SetNamingStrategy sets the strategy that derives the keys of struct
+// fields that don't set one in their tag. The default is LowerCase.
+func (enc *Encoder) SetNamingStrategy(s *NamingStrategy) {
+	enc.encoder.naming = s
+}
+
+// SetAutoInline enables or disables the inlining of embedded structs
+// and pointers to structs that don't set a key in their tag, as with
+// the inline option, following the conventions of encoding/json: fields
+// inlined from several structs may share a key, in which case the one
+// in the least deeply embedded struct is used, or the only one among
+// several at that depth that sets the key in its tag. If there's no
+// such field, none of them is used. The fields of nil embedded pointers
+// are left out.
+func (enc *Encoder) SetAutoInline(enabled bool) {
+	enc.encoder.autoInline = enabled
+}
+
+// SetOmitEmpty sets whether struct fields are omitted when empty, as if
+// all of them had the omitempty flag.
+func (enc *Encoder) SetOmitEmpty(enabled bool) {
+	enc.encoder.omitEmpty = enabled
+}
+
+// SetQuotingPolicy sets which strings enc writes quoted. The default
+// is QuoteMinimal.
+func (enc *Encoder) SetQuotingPolicy(p QuotingPolicy) {
+	enc.encoder.quoting = p
+}
+
+// SetQuoteStyle sets the style of the quotes that enc writes around
+// strings that need them. The default is DoubleQuotes.
+func (enc *Encoder) SetQuoteStyle(s QuoteStyle) {
+	enc.encoder.quotes = s
+}
+
+// SetKeyOrder sets the order in which enc writes the keys of maps.
+// Keys that are marshalled as their text with encoding.TextMarshaler
+// are ordered by it. MapSlice and OrderedMap values keep the order of
+// their items, and structs the order of their fields. The default is
+// NaturalOrder.
+func (enc *Encoder) SetKeyOrder(order KeyOrder) {
+	enc.encoder.keyOrder = order
+}
+
+// SetLineWidth sets the preferred width of the output lines, which long
+// strings are folded to fit in when possible. The default is 80, and a
+// negative width means lines are never folded. It must be called before
+// the first call to Encode.
+func (enc *Encoder) SetLineWidth(width int) {
+	yaml_emitter_set_width(&enc.encoder.emitter, width)
+}
+
+// SetAutoFlow sets whether sequences and mappings that hold only scalars
+// are written in flow style, as in "[a, b, c]", when they fit in the
+// rest of the line given the line width. Strings that must be written
+// over several lines, or in the literal or folded style, keep the
+// collection holding them in block style.
+func (enc *Encoder) SetAutoFlow(enabled bool) {
+	yaml_emitter_set_auto_flow(&enc.encoder.emitter, enabled)
+}
+
+// Encode writes the YAML encoding of v to the stream.
+// If multiple items are encoded to the stream, the
+// second and subsequent document will be preceded
+// with a "---" document separator, but the first will not.
+//
+// See the documentation for Marshal for details about the conversion
+// of Go values to YAML.
+func (enc *Encoder) Encode(v interface{}) (err error) {
+	defer handleErr(&err)
+	enc.encoder.marshalDoc("", reflect.ValueOf(v))
+	return nil
+}
+
+// Close closes the encoder by writing any remaining data.
+// It does not write a stream terminating string "...".
+func (enc *Encoder) Close() (err error) {
+	defer handleErr(&err)
+	enc.encoder.finish()
+	return nil
+}
+
 func handleErr(err *error) {
 	if v := recover(); v != nil {
 		if e, ok := v.(yamlError); ok {
@@ -179,6 +558,27 @@ func (e *TypeError) Error() string {
 	return fmt.Sprintf("yaml: unmarshal errors:\n  %s", strings.Join(e.Errors, "\n  "))
 }
 
+// Position is a location within a YAML document. Line and Column
+// start at 1.
+//
+// A struct field of type Position tagged with the ",position" option
+// receives the position of the mapping that the struct is unmarshaled
+// from, and a field of type map[string]Position tagged the same way
+// receives the position of each of the keys in that mapping. Such
+// fields aren't allowed in structs that are inlined into another.
+type Position struct {
+	Line, Column int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("line %d, column %d", p.Line, p.Column)
+}
+
+var (
+	positionType     = reflect.TypeOf(Position{})
+	keyPositionsType = reflect.TypeOf(map[string]Position{})
+)
+
 // --------------------------------------------------------------------------
 // Maintain a mapping of keys to structure field indexes
 
@@ -193,40 +593,132 @@ type structInfo struct {
 	// InlineMap is the number of the field in the struct that
 	// contains an ,inline map, or -1 if there's none.
 	InlineMap int
+
+	// HasDefaults is whether any field, or any field of a struct
+	// field, has a default value.
+	HasDefaults bool
+
+	// Position and KeyPositions are the numbers of the fields in the
+	// struct that receive the position of the mapping and of each of
+	// its keys, or -1 if there are none.
+	Position     int
+	KeyPositions int
+
+	// Aliases maps the alternative keys declared with the alias=
+	// option to the keys of their fields.
+	Aliases map[string]string
+
+	// Folded maps the lower case form of each key and alias to the
+	// key or alias itself, for keys matched case-insensitively. Forms
+	// shared by several keys or aliases are left out.
+	Folded map[string]string
+
+	// Conflicts holds, when embedded structs are inlined automatically,
+	// one of the fields of each key that was left out because several
+	// fields at the same depth have it, as these still hide the fields
+	// of enclosing structs with that key.
+	Conflicts []fieldInfo
 }
 
 type fieldInfo struct {
 	Key       string
 	Num       int
 	OmitEmpty bool
+	OmitZero  bool
 	Flow      bool
 
+	// Style is the scalar style of string values, if set by the tag.
+	Style yaml_scalar_style_t
+
+	// Base is the base that integers are written in, if set by the
+	// tag, and Precision is the number of decimals that floats are
+	// written with, or -1 for as many as needed to read them back.
+	Base      int
+	Precision int
+
 	// Inline holds the field index if the field is part of an inlined struct.
 	Inline []int
+
+	// Tagged is whether the key of the field is set in its tag.
+	Tagged bool
+
+	// Default holds the parsed default value of the field, if any.
+	Default *node
+
+	// Required is whether the field must be set by the document.
+	Required bool
+
+	// Checks holds the constraints on the field's value, if any.
+	Checks *fieldChecks
 }
 
-var structMap = make(map[reflect.Type]*structInfo)
+// structOptions holds the settings of an Encoder or Decoder that
+// change how struct fields map to keys.
+type structOptions struct {
+	// jsonTags is whether JSON conventions are used as a fallback:
+	// the json tag of fields that have no yaml tag is used in its
+	// place, and json.Marshaler and json.Unmarshaler are honored.
+	jsonTags bool
+
+	// naming derives the keys of fields without one in their tag.
+	naming *NamingStrategy
+
+	// autoInline is whether embedded structs and pointers to structs
+	// without a key in their tag are inlined, with fields of the same
+	// key hiding each other by depth as in Go.
+	autoInline bool
+}
+
+type structKey struct {
+	t    reflect.Type
+	opts structOptions
+}
+
+var structMap = make(map[structKey]*structInfo)
 var fieldMapMutex sync.RWMutex
 
-func getStructInfo(st reflect.Type) (*structInfo, error) {
+func getStructInfo(st reflect.Type, opts structOptions) (*structInfo, error) {
+	key := structKey{st, opts}
 	fieldMapMutex.RLock()
-	sinfo, found := structMap[st]
+	sinfo, found := structMap[key]
 	fieldMapMutex.RUnlock()
 	if found {
 		return sinfo, nil
 	}
 
+	sinfo, err := newStructInfo(st, opts, make(map[reflect.Type]bool))
+	if err != nil {
+		return nil, err
+	}
+
+	fieldMapMutex.Lock()
+	structMap[key] = sinfo
+	fieldMapMutex.Unlock()
+	return sinfo, nil
+}
+
+// newStructInfo computes the structInfo of st. The types in visiting are
+// those of the structs that st is being inlined into, which are skipped
+// if embedded in st again.
+func newStructInfo(st reflect.Type, opts structOptions, visiting map[reflect.Type]bool) (*structInfo, error) {
+	visiting[st] = true
+	defer delete(visiting, st)
+
 	n := st.NumField()
 	fieldsMap := make(map[string]fieldInfo)
 	fieldsList := make([]fieldInfo, 0, n)
 	inlineMap := -1
+	hasDefaults := false
+	position, keyPositions := -1, -1
+	aliases := make(map[string]string)
+	var conflicts []fieldInfo
 	for i := 0; i != n; i++ {
 		field := st.Field(i)
-		if field.PkgPath != "" {
+		if field.PkgPath != "" && !field.Anonymous {
 			continue // Private field
 		}
 
-		info := fieldInfo{Num: i}
+		info := fieldInfo{Num: i, Precision: -1}
 
 		tag := field.Tag.Get("yaml")
 		if tag == "" && strings.Index(string(field.Tag), ":") < 0 {
@@ -235,84 +727,400 @@ func getStructInfo(st reflect.Type) (*structInfo, error) {
 		if tag == "-" {
 			continue
 		}
+		if tag == "" && opts.jsonTags {
+			if jsonTag, ok := field.Tag.Lookup("json"); ok {
+				if jsonTag == "-" {
+					continue
+				}
+				tag = yamlTagFromJSON(jsonTag)
+			}
+		}
 
 		inline := false
+		isPosition := false
+		var fieldAliases []string
+		defaultValue, hasDefault := field.Tag.Lookup("default")
 		fields := strings.Split(tag, ",")
 		if len(fields) > 1 {
 			for _, flag := range fields[1:] {
-				switch flag {
-				case "omitempty":
+				switch {
+				case flag == "omitempty":
 					info.OmitEmpty = true
-				case "flow":
+				case flag == "omitzero":
+					info.OmitZero = true
+				case flag == "flow":
 					info.Flow = true
-				case "inline":
+				case scalarStyles[flag] != 0:
+					if info.Style != 0 {
+						return nil, errors.New(fmt.Sprintf("Multiple scalar styles in tag %q of type %s", tag, st))
+					}
+					info.Style = scalarStyles[flag]
+				case flag == "hex" || flag == "octal" || flag == "binary":
+					if err := info.setBase(flag, field.Type); err != nil {
+						return nil, fmt.Errorf("Invalid flag %q in tag %q of type %s: %v", flag, tag, st, err)
+					}
+				case strings.HasPrefix(flag, "prec="):
+					if err := info.setPrecision(flag[len("prec="):], field.Type); err != nil {
+						return nil, fmt.Errorf("Invalid flag %q in tag %q of type %s: %v", flag, tag, st, err)
+					}
+				case flag == "inline":
 					inline = true
+				case flag == "required":
+					info.Required = true
+				case flag == "position":
+					isPosition = true
+				case strings.HasPrefix(flag, "alias="):
+					fieldAliases = append(fieldAliases, flag[len("alias="):])
+				case strings.HasPrefix(flag, "default="):
+					if hasDefault {
+						return nil, errors.New(fmt.Sprintf("Duplicated default value in tag %q of type %s", tag, st))
+					}
+					defaultValue, hasDefault = flag[len("default="):], true
 				default:
-					return nil, errors.New(fmt.Sprintf("Unsupported flag %q in tag %q of type %s", flag, tag, st))
+					ok, err := info.parseCheck(flag, field.Type)
+					if err != nil {
+						return nil, fmt.Errorf("Invalid flag %q in tag %q of type %s: %v", flag, tag, st, err)
+					}
+					if !ok {
+						return nil, errors.New(fmt.Sprintf("Unsupported flag %q in tag %q of type %s", flag, tag, st))
+					}
 				}
 			}
 			tag = fields[0]
 		}
 
+		inlineType := field.Type
+		if inlineType.Kind() == reflect.Ptr && inlineType.Elem().Kind() == reflect.Struct {
+			inlineType = inlineType.Elem()
+		}
+		if !inline && opts.autoInline && field.Anonymous && tag == "" {
+			inline = inlineType.Kind() == reflect.Struct
+		}
+		if field.PkgPath != "" && !(inline && inlineType.Kind() == reflect.Struct && field.Type == inlineType) {
+			// Only the fields of private embedded struct values
+			// are reachable, if the struct is inlined.
+			continue
+		}
+
+		if isPosition {
+			switch field.Type {
+			case positionType:
+				if position >= 0 {
+					return nil, errors.New("Multiple ,position fields in struct " + st.String())
+				}
+				position = i
+			case keyPositionsType:
+				if keyPositions >= 0 {
+					return nil, errors.New("Multiple ,position maps in struct " + st.String())
+				}
+				keyPositions = i
+			default:
+				return nil, errors.New("Option ,position needs a yaml.Position or map[string]yaml.Position field in struct " + st.String())
+			}
+			continue
+		}
+
+		if pattern, ok := field.Tag.Lookup("pattern"); ok {
+			if err := info.setPattern(pattern, field.Type); err != nil {
+				return nil, fmt.Errorf("Invalid pattern %q for field %s of type %s: %v", pattern, field.Name, st, err)
+			}
+		}
+
+		if inline && fieldAliases != nil {
+			return nil, errors.New("Option ,inline can't have aliases in struct " + st.String())
+		}
+
+		if hasDefault {
+			if inline {
+				return nil, errors.New("Option ,inline can't have a default value in struct " + st.String())
+			}
+			dn, err := parseDefault(defaultValue)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid default value %q for field %s of type %s: %v", defaultValue, field.Name, st, err)
+			}
+			info.Default = dn
+			hasDefaults = true
+		} else if !inline && field.Type.Kind() == reflect.Struct {
+			if fsinfo, err := getStructInfo(field.Type, opts); err == nil && fsinfo.HasDefaults {
+				hasDefaults = true
+			}
+		}
+
 		if inline {
-			switch field.Type.Kind() {
+			switch inlineType.Kind() {
 			case reflect.Map:
 				if inlineMap >= 0 {
 					return nil, errors.New("Multiple ,inline maps in struct " + st.String())
 				}
-				if field.Type.Key() != reflect.TypeOf("") {
-					return nil, errors.New("Option ,inline needs a map with string keys in struct " + st.String())
+				if !isInlineMapKey(field.Type.Key()) {
+					return nil, errors.New("Option ,inline needs a map with string, interface{} or encoding.TextUnmarshaler keys in struct " + st.String())
 				}
 				inlineMap = info.Num
 			case reflect.Struct:
-				sinfo, err := getStructInfo(field.Type)
+				if visiting[inlineType] {
+					continue // Embedded in itself
+				}
+				sinfo, err := newStructInfo(inlineType, opts, visiting)
 				if err != nil {
 					return nil, err
 				}
+				if sinfo.Position >= 0 || sinfo.KeyPositions >= 0 {
+					return nil, errors.New("Option ,position can't be used in inlined struct " + inlineType.String() + " of struct " + st.String())
+				}
+				if sinfo.HasDefaults {
+					hasDefaults = true
+				}
 				for _, finfo := range sinfo.FieldsList {
-					if _, found := fieldsMap[finfo.Key]; found {
+					if _, found := fieldsMap[finfo.Key]; found && !opts.autoInline {
 						msg := "Duplicated key '" + finfo.Key + "' in struct " + st.String()
 						return nil, errors.New(msg)
 					}
-					if finfo.Inline == nil {
-						finfo.Inline = []int{i, finfo.Num}
-					} else {
-						finfo.Inline = append([]int{i}, finfo.Inline...)
+					finfo.Inline = inlineIndex(i, finfo)
+					if !opts.autoInline {
+						fieldsMap[finfo.Key] = finfo
 					}
-					fieldsMap[finfo.Key] = finfo
 					fieldsList = append(fieldsList, finfo)
 				}
+				for _, finfo := range sinfo.Conflicts {
+					finfo.Inline = inlineIndex(i, finfo)
+					conflicts = append(conflicts, finfo)
+				}
+				for alias, key := range sinfo.Aliases {
+					if _, found := aliases[alias]; found {
+						return nil, errors.New("Duplicated alias '" + alias + "' in struct " + st.String())
+					}
+					aliases[alias] = key
+				}
 			default:
-				//return nil, errors.New("Option ,inline needs a struct value or map field")
-				return nil, errors.New("Option ,inline needs a struct value field")
+				return nil, errors.New("Option ,inline needs a struct, pointer to struct or map field in struct " + st.String())
 			}
 			continue
 		}
 
 		if tag != "" {
 			info.Key = tag
+			info.Tagged = true
 		} else {
-			info.Key = strings.ToLower(field.Name)
+			info.Key = opts.naming.fieldKey(field.Name)
 		}
 
-		if _, found = fieldsMap[info.Key]; found {
+		if _, found := fieldsMap[info.Key]; found {
 			msg := "Duplicated key '" + info.Key + "' in struct " + st.String()
 			return nil, errors.New(msg)
 		}
 
 		fieldsList = append(fieldsList, info)
 		fieldsMap[info.Key] = info
+
+		for _, alias := range fieldAliases {
+			if _, found := aliases[alias]; found {
+				return nil, errors.New("Duplicated alias '" + alias + "' in struct " + st.String())
+			}
+			aliases[alias] = info.Key
+		}
 	}
 
-	sinfo = &structInfo{fieldsMap, fieldsList, inlineMap}
+	if opts.autoInline {
+		fieldsList, conflicts = dominantFields(fieldsList, conflicts)
+		for _, info := range fieldsList {
+			fieldsMap[info.Key] = info
+		}
+		for alias, key := range aliases {
+			if _, found := fieldsMap[key]; !found {
+				delete(aliases, alias)
+			}
+		}
+	}
 
-	fieldMapMutex.Lock()
-	structMap[st] = sinfo
-	fieldMapMutex.Unlock()
-	return sinfo, nil
+	folded := make(map[string]string)
+	ambiguous := make(map[string]bool)
+	fold := func(name string) {
+		lower := strings.ToLower(name)
+		if _, found := folded[lower]; found {
+			ambiguous[lower] = true
+		}
+		folded[lower] = name
+	}
+	for key := range fieldsMap {
+		fold(key)
+	}
+	for alias := range aliases {
+		if _, found := fieldsMap[alias]; found {
+			return nil, errors.New("Alias '" + alias + "' duplicates a key in struct " + st.String())
+		}
+		fold(alias)
+	}
+	for lower := range ambiguous {
+		delete(folded, lower)
+	}
+
+	return &structInfo{
+		FieldsMap:    fieldsMap,
+		FieldsList:   fieldsList,
+		InlineMap:    inlineMap,
+		HasDefaults:  hasDefaults,
+		Position:     position,
+		KeyPositions: keyPositions,
+		Aliases:      aliases,
+		Folded:       folded,
+		Conflicts:    conflicts,
+	}, nil
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// isInlineMapKey returns whether the keys of an ,inline map may be of
+// type t, which is the case if mapping keys can be decoded into it.
+func isInlineMapKey(t reflect.Type) bool {
+	return t == reflect.TypeOf("") || t == ifaceType || reflect.PtrTo(t).Implements(textUnmarshalerType)
+}
+
+// scalarStyles maps the tag flags that select a scalar style to it.
+var scalarStyles = map[string]yaml_scalar_style_t{
+	"literal":      yaml_LITERAL_SCALAR_STYLE,
+	"folded":       yaml_FOLDED_SCALAR_STYLE,
+	"singlequoted": yaml_SINGLE_QUOTED_SCALAR_STYLE,
+	"doublequoted": yaml_DOUBLE_QUOTED_SCALAR_STYLE,
+}
+
+// inlineIndex returns the index of the field of an inlined struct
+// described by info, within the struct where it's inlined as field i.
+func inlineIndex(i int, info fieldInfo) []int {
+	if info.Inline == nil {
+		return []int{i, info.Num}
+	}
+	return append([]int{i}, info.Inline...)
+}
+
+// value returns the field described by info in the struct v. Nil
+// pointers to inlined structs on the way to it are allocated if alloc
+// is true, and otherwise result in the zero Value.
+func (info *fieldInfo) value(v reflect.Value, alloc bool) reflect.Value {
+	if info.Inline == nil {
+		return v.Field(info.Num)
+	}
+	for i, x := range info.Inline {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc {
+					return reflect.Value{}
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// depth returns the number of inlined structs that the field is in.
+func (info *fieldInfo) depth() int {
+	if info.Inline == nil {
+		return 0
+	}
+	return len(info.Inline) - 1
+}
+
+// dominantFields resolves the keys shared by several of the fields in
+// list, or by them and the conflicts of inlined structs, following the
+// rules of Go for embedded fields: the field at the least depth wins,
+// unless there are several of them, in which case the only one with its
+// key in its tag wins. The fields of keys that are left without one are
+// dropped, and one of them for each key is returned in conflicts.
+func dominantFields(list, inlined []fieldInfo) (fields, conflicts []fieldInfo) {
+	type candidate struct {
+		info     *fieldInfo
+		conflict bool
+	}
+	var keys []string
+	byKey := make(map[string][]candidate)
+	add := func(info *fieldInfo, conflict bool) {
+		if _, found := byKey[info.Key]; !found {
+			keys = append(keys, info.Key)
+		}
+		byKey[info.Key] = append(byKey[info.Key], candidate{info, conflict})
+	}
+	for i := range list {
+		add(&list[i], false)
+	}
+	for i := range inlined {
+		add(&inlined[i], true)
+	}
+	dominant := make(map[*fieldInfo]bool)
+	for _, key := range keys {
+		var shallowest, tagged []candidate
+		for _, c := range byKey[key] {
+			if len(shallowest) > 0 {
+				depth := shallowest[0].info.depth()
+				if c.info.depth() > depth {
+					continue
+				}
+				if c.info.depth() < depth {
+					shallowest, tagged = nil, nil
+				}
+			}
+			shallowest = append(shallowest, c)
+			if c.info.Tagged {
+				tagged = append(tagged, c)
+			}
+		}
+		winner := shallowest[0]
+		if len(shallowest) > 1 {
+			if len(tagged) == 1 {
+				winner = tagged[0]
+			} else {
+				winner = candidate{shallowest[0].info, true}
+			}
+		}
+		if !winner.conflict {
+			dominant[winner.info] = true
+			continue
+		}
+		conflict := *winner.info
+		conflict.Tagged = len(tagged) > 0
+		conflicts = append(conflicts, conflict)
+	}
+	for i := range list {
+		if dominant[&list[i]] {
+			fields = append(fields, list[i])
+		}
+	}
+	return fields, conflicts
+}
+
+// parseDefault parses the default value of a field as a YAML document,
+// so that it is resolved like the value of the field in a document.
+func parseDefault(s string) (n *node, err error) {
+	defer handleErr(&err)
+	p := newParser([]byte(s))
+	defer p.destroy()
+	doc := p.parse()
+	if doc == nil {
+		return &node{kind: scalarNode, implicit: true}, nil
+	}
+	if len(doc.anchors) > 0 || hasAlias(doc) {
+		return nil, errors.New("anchors and aliases are not supported in default values")
+	}
+	return doc.children[0], nil
+}
+
+func hasAlias(n *node) bool {
+	if n.kind == aliasNode {
+		return true
+	}
+	for _, child := range n.children {
+		if hasAlias(child) {
+			return true
+		}
+	}
+	return false
 }
 
 func isZero(v reflect.Value) bool {
+	if zero, ok := isZeroer(v); ok {
+		return zero
+	}
 	switch v.Kind() {
 	case reflect.String:
 		return len(v.String()) == 0
@@ -332,6 +1140,9 @@ func isZero(v reflect.Value) bool {
 		return !v.Bool()
 	case reflect.Struct:
 		vt := v.Type()
+		if vt == orderedMapType {
+			return v.Field(0).Len() == 0
+		}
 		for i := v.NumField() - 1; i >= 0; i-- {
 			if vt.Field(i).PkgPath != "" {
 				continue // Private field
@@ -344,3 +1155,52 @@ func isZero(v reflect.Value) bool {
 	}
 	return false
 }
+
+// isZeroValue returns whether v is the zero value of its type, or the
+// result of its IsZero method if it implements IsZeroer.
+func isZeroValue(v reflect.Value) bool {
+	if zero, ok := isZeroer(v); ok {
+		return zero
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Func, reflect.Chan:
+		return v.IsNil()
+	case reflect.Complex64, reflect.Complex128:
+		return v.Complex() == 0
+	case reflect.UnsafePointer:
+		return v.Pointer() == 0
+	case reflect.Array:
+		for i := v.Len() - 1; i >= 0; i-- {
+			if !isZeroValue(v.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Struct:
+		for i := v.NumField() - 1; i >= 0; i-- {
+			if !isZeroValue(v.Field(i)) {
+				return false
+			}
+		}
+		return true
+	}
+	return isZero(v)
+}
+
+// isZeroer returns the result of the IsZero method of v, if v implements
+// IsZeroer. Nil pointers and interfaces are always zero.
+func isZeroer(v reflect.Value) (zero, ok bool) {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return true, true
+		}
+	}
+	if !v.CanInterface() {
+		return false, false
+	}
+	if z, ok := v.Interface().(IsZeroer); ok {
+		return z.IsZero(), true
+	}
+	return false, false
+}
@@ -61,6 +61,8 @@ type yaml_mark_t struct {
 	index  int // The position index.
 	line   int // The position line.
 	column int // The position column.
+
+	source_index int // [Go] The position index in the source (in bytes).
 }
 
 // Node Styles
@@ -237,6 +239,9 @@ const (
 	yaml_SEQUENCE_END_EVENT   // A SEQUENCE-END event.
 	yaml_MAPPING_START_EVENT  // A MAPPING-START event.
 	yaml_MAPPING_END_EVENT    // A MAPPING-END event.
+
+	// [Go] A RAW event, with YAML text that is emitted verbatim.
+	yaml_RAW_EVENT
 )
 
 // The event structure.
@@ -263,7 +268,7 @@ type yaml_event_t struct {
 	// The tag (for yaml_SCALAR_EVENT, yaml_SEQUENCE_START_EVENT, yaml_MAPPING_START_EVENT).
 	tag []byte
 
-	// The scalar value (for yaml_SCALAR_EVENT).
+	// The scalar value (for yaml_SCALAR_EVENT), or the YAML text (for yaml_RAW_EVENT).
 	value []byte
 
 	// Is the document start/end indicator implicit, or the tag optional?
@@ -274,6 +279,7 @@ type yaml_event_t struct {
 	quoted_implicit bool
 
 	// The style (for yaml_SCALAR_EVENT, yaml_SEQUENCE_START_EVENT, yaml_MAPPING_START_EVENT).
+	// [Go] For yaml_RAW_EVENT, the block style of the collection in the text, if any.
 	style yaml_style_t
 }
 
@@ -521,9 +527,9 @@ type yaml_parser_t struct {
 
 	read_handler yaml_read_handler_t // Read handler.
 
-	input_file io.Reader // File input data.
-	input      []byte    // String input data.
-	input_pos  int
+	input_reader io.Reader // Reader input data.
+	input        []byte    // String input data.
+	input_pos    int
 
 	eof bool // EOF flag
 
@@ -540,6 +546,13 @@ type yaml_parser_t struct {
 	offset int         // The offset of the current position (in bytes).
 	mark   yaml_mark_t // The mark of the current position.
 
+	// [Go] The source text that the source indexes of marks refer to.
+	// It's the input itself for UTF-8 strings, and is otherwise captured
+	// from the decoded characters when capture_source is set.
+	source         []byte
+	source_offset  int  // The index of the first byte in source.
+	capture_source bool // Capture the source from the decoded characters.
+
 	// Scanner stuff
 
 	stream_start_produced bool // Have we started to scan the input stream?
@@ -647,6 +660,7 @@ type yaml_emitter_t struct {
 	canonical   bool         // If the output is in the canonical style?
 	best_indent int          // The number of indentation spaces.
 	best_width  int          // The preferred width of the output lines.
+	auto_flow   bool         // [Go] Emit block collections of scalars in flow style if they fit in the line?
 	unicode     bool         // Allow unescaped non-ASCII characters?
 	line_break  yaml_break_t // The preferred line break.
 